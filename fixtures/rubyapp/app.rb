@@ -14,7 +14,8 @@ class AppServer < Sinatra::Base
 
   get '/config' do
     puts "Sending a request to the config-server sidecar at localhost:#{ENV['CONFIG_SERVER_PORT']}/config/"
-    response = Typhoeus.get("localhost:#{ENV['CONFIG_SERVER_PORT']}/config/")
+    response = Typhoeus.get("localhost:#{ENV['CONFIG_SERVER_PORT']}/config/",
+      headers: { "Authorization" => "Bearer #{ENV['CONFIG_SERVER_TOKEN']}" })
     if response.body.size > 0
       puts "Received #{response.body} from the config-server sidecar"
       STDOUT.flush
@@ -10,18 +10,15 @@ ROOT="$( cd "$( dirname "${BASH_SOURCE[0]}" )/.." && pwd )"
 cd $ROOT
 
 set -eu
+source .envrc
 
 version=$(cat VERSION)
 BUCKET=${BUCKET:-sample1-sidecar-buildpack}
 
 echo "Building config-server-v${version}.tar.xz"
-cd src/config-server-sidecar
-GOOS=linux GOARCH=amd64 go build -o config-server .
-cd -
-
 output_dir=$(mktemp -d -t buildpackXXX)
 mkdir -p $output_dir/bin
-mv src/config-server-sidecar/config-server $output_dir/bin/
+GOOS=linux GOARCH=amd64 go build -ldflags="-X sample3-sidecar/configserver.Version=${version}" -o $output_dir/bin/config-server sample3-sidecar/configserver/cli
 tar -cJ -C $output_dir -f config-server-v${version}.tar.xz .
 
 
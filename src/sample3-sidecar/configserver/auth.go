@@ -0,0 +1,46 @@
+package configserver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authenticate rejects requests that do not carry the server token, or the
+// token of an identity in the access policy, as an "Authorization: Bearer"
+// header. Requests are let through unchecked when no token is configured.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ok := true
+		switch {
+		case s.Policy != nil:
+			req, ok = s.identify(req)
+		case s.Token != "":
+			ok = tokenMatches(bearerToken(req), s.Token)
+		}
+		if !ok {
+			s.Log.Printf("Rejected unauthenticated request for %s.", req.URL.Path)
+			res.Header().Set("WWW-Authenticate", `Bearer realm="config-server"`)
+			http.Error(res, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+func bearerToken(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// tokenMatches compares tokens in constant time. Both are hashed first so
+// that the comparison does not leak the length of the expected token.
+func tokenMatches(given, expected string) bool {
+	a := sha256.Sum256([]byte(given))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
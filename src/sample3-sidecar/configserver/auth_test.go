@@ -0,0 +1,56 @@
+package configserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authentication", func() {
+	var server *configserver.Server
+
+	get := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, req)
+		return res
+	}
+
+	BeforeEach(func() {
+		server = newServer(&configserver.Source{Name: "application", Origin: "test", Data: map[string]interface{}{"key": "value"}})
+	})
+
+	It("serves every request when no token is configured", func() {
+		Expect(get("/config/", "").Code).To(Equal(http.StatusOK))
+	})
+
+	Context("with a token", func() {
+		BeforeEach(func() {
+			server.Token = "s3cret"
+		})
+
+		It("serves requests presenting the token", func() {
+			Expect(get("/config/", "Bearer s3cret").Code).To(Equal(http.StatusOK))
+			Expect(get("/application/default", "bearer s3cret").Code).To(Equal(http.StatusOK))
+		})
+
+		It("rejects requests without the token", func() {
+			res := get("/config/", "")
+			Expect(res.Code).To(Equal(http.StatusUnauthorized))
+			Expect(res.Header().Get("WWW-Authenticate")).To(Equal(`Bearer realm="config-server"`))
+			Expect(res.Body.String()).NotTo(ContainSubstring("value"))
+		})
+
+		It("rejects requests with the wrong token or scheme", func() {
+			Expect(get("/config/", "Bearer s3cre").Code).To(Equal(http.StatusUnauthorized))
+			Expect(get("/config/", "Bearer s3cret-and-more").Code).To(Equal(http.StatusUnauthorized))
+			Expect(get("/config/", "Basic s3cret").Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
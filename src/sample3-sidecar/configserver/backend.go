@@ -0,0 +1,304 @@
+package configserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backend loads configuration documents from a store.
+type Backend interface {
+	// Name identifies the backend in logs.
+	Name() string
+	// Load returns every document held by the backend.
+	Load() ([]*Source, error)
+}
+
+// RemoteBackend is implemented by backends that load documents from a
+// network service, which may be down while the app starts.
+type RemoteBackend interface {
+	Backend
+	// Remote reports whether the backend loads over the network.
+	Remote() bool
+}
+
+// LabeledBackend is implemented by backends that hold several versions of
+// their documents, such as the branches and tags of a git repository. A
+// label, as in the Spring Cloud Config API, selects a version.
+type LabeledBackend interface {
+	Backend
+	// Revision returns the version label selects, such as a commit hash, so
+	// that labels selecting the same version can share its documents. It
+	// fails with a *LabelNotFoundError if the backend has no such label.
+	Revision(label string) (string, error)
+	// LoadLabel returns every document at label. It fails with a
+	// *LabelNotFoundError if the backend has no such label.
+	LoadLabel(label string) ([]*Source, error)
+}
+
+// LabelNotFoundError reports a label a labeled backend does not hold.
+type LabelNotFoundError struct {
+	Label string
+}
+
+func (e *LabelNotFoundError) Error() string {
+	return fmt.Sprintf("label %q not found", e.Label)
+}
+
+// FileBackend loads YAML and JSON files matched by glob patterns, except
+// the files in Exclude.
+type FileBackend struct {
+	Patterns []string
+	Exclude  []string
+}
+
+func (b *FileBackend) Name() string {
+	return "file"
+}
+
+func (b *FileBackend) Load() ([]*Source, error) {
+	return loadSources(b.Patterns, b.Exclude)
+}
+
+// NewBackends returns the backends selected by settings, in order of
+// increasing precedence.
+func NewBackends(settings Settings) ([]Backend, error) {
+	var backends []Backend
+	for _, name := range settings.Backends {
+		switch name {
+		case "file":
+			backends = append(backends, &FileBackend{Patterns: settings.Sources, Exclude: settings.privateFiles()})
+		case "env":
+			backends = append(backends, &EnvBackend{Prefix: settings.EnvPrefix})
+		case "vault":
+			vault, err := newVaultBackend(settings.Vault)
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, vault)
+		case "credhub":
+			credhub, err := newCredHubBackend(settings.CredHub)
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, credhub)
+		case "git":
+			backends = append(backends, &GitBackend{
+				Repository:  settings.Git.Repository,
+				Label:       settings.Git.Label,
+				SearchPaths: settings.Git.SearchPaths,
+			})
+		case "services":
+			backends = append(backends, &ServicesBackend{
+				Services:     settings.Services,
+				MappingsFile: settings.ServiceMappings,
+			})
+		default:
+			return nil, fmt.Errorf("unknown config backend %q", name)
+		}
+	}
+	return backends, nil
+}
+
+// LoadBackends loads every backend in order, so that documents from later
+// backends take precedence when merged.
+func LoadBackends(backends []Backend) ([]*Source, error) {
+	return loadBackends(backends, nil)
+}
+
+// loadBackends loads the labeled backends in labels at their label, and
+// the other backends as LoadBackends does.
+func loadBackends(backends []Backend, labels map[Backend]string) ([]*Source, error) {
+	var sources []*Source
+	for _, backend := range backends {
+		var (
+			loaded []*Source
+			err    error
+		)
+		if labeled, ok := backend.(LabeledBackend); ok && labels[backend] != "" {
+			loaded, err = labeled.LoadLabel(labels[backend])
+		} else {
+			loaded, err = backend.Load()
+		}
+		if err != nil {
+			remote, ok := backend.(RemoteBackend)
+			return nil, &BackendError{Backend: backend.Name(), Remote: ok && remote.Remote(), Err: err}
+		}
+		sources = append(sources, loaded...)
+	}
+	return sources, nil
+}
+
+// BackendError reports which backend failed to load. Remote is set when
+// the backend loads over the network.
+type BackendError struct {
+	Backend string
+	Remote  bool
+	Err     error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("%s backend: %s", e.Backend, e.Err)
+}
+
+func newVaultBackend(settings HTTPBackendSettings) (*VaultBackend, error) {
+	client, err := newHTTPClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &VaultBackend{
+		Address: settings.Address,
+		Token:   settings.Token,
+		Mount:   settings.Mount,
+		Prefix:  settings.Prefix,
+		Client:  client,
+	}, nil
+}
+
+func newCredHubBackend(settings HTTPBackendSettings) (*CredHubBackend, error) {
+	client, err := newHTTPClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &CredHubBackend{
+		Address: settings.Address,
+		Token:   settings.Token,
+		Prefix:  settings.Prefix,
+		Client:  client,
+	}, nil
+}
+
+func newHTTPClient(settings HTTPBackendSettings) (*http.Client, error) {
+	config := &tls.Config{InsecureSkipVerify: settings.SkipSSLValidation}
+	if settings.ClientCert != "" || settings.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(settings.ClientCert, settings.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: config, Proxy: http.ProxyFromEnvironment},
+	}, nil
+}
+
+// sortedSources returns one source per document, ordered by name.
+func sortedSources(documents map[string]map[string]interface{}, origin func(name string) string) []*Source {
+	names := make([]string, 0, len(documents))
+	for name := range documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sources := make([]*Source, len(names))
+	for i, name := range names {
+		sources[i] = &Source{Name: name, Origin: origin(name), Data: documents[name]}
+	}
+	return sources
+}
+
+// setNested stores value in document under the given key path, creating
+// intermediate mappings as needed.
+func setNested(document map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := document[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			document[key] = child
+		}
+		document = child
+	}
+	document[path[len(path)-1]] = value
+}
+
+func trimSlashes(s string) string {
+	return strings.Trim(s, "/")
+}
+
+// getJSON decodes the JSON response to req into out, returning false if the
+// resource does not exist.
+func getJSON(client *http.Client, req *http.Request, out interface{}) (bool, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GET %s: unexpected status %s", redactQuery(req.URL), res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("GET %s: %s", redactQuery(req.URL), err)
+	}
+	return true, nil
+}
+
+// sendJSON sends body as JSON to url with the given method and header,
+// failing unless the response is successful.
+func sendJSON(client *http.Client, method, url string, header http.Header, body interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s %s: unexpected status %s", method, redactQuery(req.URL), res.Status)
+	}
+	return nil
+}
+
+// checkHealth requests a health endpoint and fails unless it returns 200.
+func checkHealth(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", redactQuery(req.URL), res.Status)
+	}
+	return nil
+}
+
+func redactQuery(u *url.URL) string {
+	redacted := *u
+	redacted.RawQuery = ""
+	return redacted.String()
+}
@@ -0,0 +1,309 @@
+package configserver_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type failingBackend struct{}
+
+func (failingBackend) Name() string {
+	return "failing"
+}
+
+func (failingBackend) Load() ([]*configserver.Source, error) {
+	return nil, errors.New("unreachable")
+}
+
+var _ = Describe("Backends", func() {
+	Describe("NewBackends", func() {
+		It("creates the backends in the configured order", func() {
+			backends, err := configserver.NewBackends(configserver.Settings{
+				Backends: []string{"file", "env", "vault", "credhub"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backends).To(HaveLen(4))
+			Expect(backends[0].Name()).To(Equal("file"))
+			Expect(backends[3].Name()).To(Equal("credhub"))
+		})
+
+		It("never serves the access policy or vars files matched by the sources", func() {
+			dir, err := ioutil.TempDir("", "configserver")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			Expect(os.MkdirAll(filepath.Join(dir, "config"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "config", "billing.yml"), []byte("region: eu\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "config", "access-policy.yml"), []byte(accessPolicy), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "config", "vars.yml"), []byte("password: s3cret\n"), 0644)).To(Succeed())
+
+			env := map[string]string{"HOME": dir, "CONFIG_SERVER_VARS_FILES": "config/vars.yml"}
+			settings, err := configserver.NewSettings(func(name string) string { return env[name] })
+			Expect(err).NotTo(HaveOccurred())
+			backends, err := configserver.NewBackends(settings)
+			Expect(err).NotTo(HaveOccurred())
+			server := configserver.NewServer(backends, log.New(ioutil.Discard, "", 0))
+			Expect(server.Load()).To(Succeed())
+
+			for path, status := range map[string]int{"/config/billing": http.StatusOK, "/config/access-policy": http.StatusNotFound, "/config/vars": http.StatusNotFound} {
+				res := httptest.NewRecorder()
+				server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+				Expect(res.Code).To(Equal(status), path)
+			}
+		})
+
+		It("rejects unknown backends", func() {
+			_, err := configserver.NewBackends(configserver.Settings{Backends: []string{"consul"}})
+			Expect(err).To(MatchError(`unknown config backend "consul"`))
+		})
+	})
+
+	Describe("LoadBackends", func() {
+		It("names the backend that failed", func() {
+			_, err := configserver.LoadBackends([]configserver.Backend{staticBackend{}, failingBackend{}})
+			Expect(err).To(MatchError("failing backend: unreachable"))
+		})
+	})
+
+	Describe("EnvBackend", func() {
+		It("builds documents from prefixed variables", func() {
+			backend := &configserver.EnvBackend{Environ: func() []string {
+				return []string{
+					"CONFIG_DOC_BILLING__DB__URL=postgres://localhost",
+					"CONFIG_DOC_BILLING_PROD__DB__URL=postgres://prod",
+					"CONFIG_DOC_BILLING__API_KEY=a=b",
+					"CONFIG_SERVER_PORT=8082",
+					"CONFIG_DOC_NOKEY=ignored",
+				}
+			}}
+
+			sources, err := backend.Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources).To(HaveLen(2))
+			Expect(sources[0].Name).To(Equal("billing"))
+			Expect(sources[0].Origin).To(Equal("env:CONFIG_DOC_BILLING"))
+			Expect(sources[0].Data).To(Equal(map[string]interface{}{
+				"db":      map[string]interface{}{"url": "postgres://localhost"},
+				"api_key": "a=b",
+			}))
+			Expect(sources[1].Name).To(Equal("billing-prod"))
+		})
+	})
+
+	Describe("VaultBackend", func() {
+		var (
+			vault    *httptest.Server
+			requests []*http.Request
+			written  map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			requests = nil
+			vault = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r)
+				if r.URL.Path == "/v1/sys/health" {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				if r.Header.Get("X-Vault-Token") != "s.token" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				switch r.URL.Path {
+				case "/v1/kv/metadata/apps":
+					Expect(r.URL.Query().Get("list")).To(Equal("true"))
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"data": map[string]interface{}{"keys": []string{"billing,prod", "application", "nested/"}},
+					})
+				case "/v1/kv/data/apps/application":
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"data": map[string]interface{}{"data": map[string]interface{}{"password": "shared"}},
+					})
+				case "/v1/kv/data/apps/session_key":
+					Expect(r.Method).To(Equal("POST"))
+					Expect(json.NewDecoder(r.Body).Decode(&written)).To(Succeed())
+				case "/v1/kv/data/apps/billing,prod":
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"data": map[string]interface{}{"data": map[string]interface{}{"db": map[string]interface{}{"password": "prod"}}},
+					})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+		})
+
+		AfterEach(func() {
+			vault.Close()
+		})
+
+		It("loads each secret under the prefix as a document", func() {
+			backend := &configserver.VaultBackend{Address: vault.URL, Token: "s.token", Mount: "kv", Prefix: "/apps/"}
+
+			sources, err := backend.Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources).To(HaveLen(2))
+			Expect(sources[0].Name).To(Equal("application"))
+			Expect(sources[0].Origin).To(Equal("vault:kv/data/apps/application"))
+			Expect(sources[0].Data).To(Equal(map[string]interface{}{"password": "shared"}))
+			Expect(sources[1].Name).To(Equal("billing-prod"))
+			Expect(sources[1].Data).To(Equal(map[string]interface{}{"db": map[string]interface{}{"password": "prod"}}))
+		})
+
+		It("returns no documents when the prefix does not exist", func() {
+			backend := &configserver.VaultBackend{Address: vault.URL, Token: "s.token", Mount: "kv", Prefix: "missing"}
+
+			sources, err := backend.Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources).To(BeEmpty())
+		})
+
+		It("resolves variables from secrets", func() {
+			backend := &configserver.VaultBackend{Address: vault.URL, Token: "s.token", Mount: "kv", Prefix: "apps"}
+
+			value, found, err := backend.Variable("application")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(value).To(Equal(map[string]interface{}{"password": "shared"}))
+
+			_, found, err = backend.Variable("missing")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+		})
+
+		It("stores variables only if they do not exist", func() {
+			backend := &configserver.VaultBackend{Address: vault.URL, Token: "s.token", Mount: "kv", Prefix: "apps"}
+
+			Expect(backend.SetVariable("session_key", "s3cret")).To(Succeed())
+			Expect(written).To(Equal(map[string]interface{}{
+				"options": map[string]interface{}{"cas": 0.0},
+				"data":    map[string]interface{}{"value": "s3cret"},
+			}))
+		})
+
+		It("checks vault health", func() {
+			backend := &configserver.VaultBackend{Address: vault.URL}
+			Expect(backend.Check()).To(Succeed())
+
+			vault.Close()
+			Expect(backend.Check()).NotTo(Succeed())
+		})
+
+		It("fails when vault rejects the token", func() {
+			backend := &configserver.VaultBackend{Address: vault.URL, Token: "wrong", Mount: "kv", Prefix: "apps"}
+
+			_, err := backend.Load()
+			Expect(err).To(MatchError(ContainSubstring("unexpected status 403 Forbidden")))
+		})
+	})
+
+	Describe("CredHubBackend", func() {
+		var (
+			credhub *httptest.Server
+			written map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			written = nil
+			credhub = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/health" {
+					w.Write([]byte(`{"status":"UP"}`))
+					return
+				}
+				Expect(r.URL.Path).To(Equal("/api/v1/data"))
+				if r.Header.Get("Authorization") != "Bearer uaa-token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if r.Method == "PUT" {
+					Expect(json.NewDecoder(r.Body).Decode(&written)).To(Succeed())
+					w.Write([]byte(`{}`))
+					return
+				}
+
+				query := r.URL.Query()
+				if query.Get("path") == "/config-server" {
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"credentials": []map[string]string{
+							{"name": "/config-server/billing/default/db/password"},
+							{"name": "/config-server/billing/prod/api"},
+							{"name": "/config-server/too-short"},
+						},
+					})
+					return
+				}
+
+				Expect(query.Get("current")).To(Equal("true"))
+				values := map[string]interface{}{
+					"/config-server/billing/default/db/password": "hunter2",
+					"/config-server/billing/prod/api":            map[string]interface{}{"key": "abc"},
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": []map[string]interface{}{{"type": "json", "value": values[query.Get("name")]}},
+				})
+			}))
+		})
+
+		AfterEach(func() {
+			credhub.Close()
+		})
+
+		It("builds documents from credentials under the prefix", func() {
+			backend := &configserver.CredHubBackend{Address: credhub.URL, Token: "uaa-token", Prefix: configserver.DefaultCredHubPrefix}
+
+			sources, err := backend.Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources).To(HaveLen(2))
+			Expect(sources[0].Name).To(Equal("billing"))
+			Expect(sources[0].Origin).To(Equal("credhub:/config-server/billing/default"))
+			Expect(sources[0].Data).To(Equal(map[string]interface{}{"db": map[string]interface{}{"password": "hunter2"}}))
+			Expect(sources[1].Name).To(Equal("billing-prod"))
+			Expect(sources[1].Data).To(Equal(map[string]interface{}{"api": map[string]interface{}{"key": "abc"}}))
+		})
+
+		It("resolves variables from credentials under the prefix", func() {
+			backend := &configserver.CredHubBackend{Address: credhub.URL, Token: "uaa-token", Prefix: configserver.DefaultCredHubPrefix}
+
+			value, found, err := backend.Variable("billing/default/db/password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(value).To(Equal("hunter2"))
+
+			value, _, _ = backend.Variable("/config-server/billing/prod/api")
+			Expect(value).To(Equal(map[string]interface{}{"key": "abc"}))
+		})
+
+		It("stores variables without overwriting existing credentials", func() {
+			backend := &configserver.CredHubBackend{Address: credhub.URL, Token: "uaa-token", Prefix: configserver.DefaultCredHubPrefix}
+
+			Expect(backend.SetVariable("session_key", "s3cret")).To(Succeed())
+			Expect(written).To(Equal(map[string]interface{}{
+				"name":  "/config-server/session_key",
+				"type":  "value",
+				"value": "s3cret",
+				"mode":  "no-overwrite",
+			}))
+		})
+
+		It("checks credhub health", func() {
+			backend := &configserver.CredHubBackend{Address: credhub.URL}
+			Expect(backend.Check()).To(Succeed())
+		})
+
+		It("fails when credhub rejects the token", func() {
+			backend := &configserver.CredHubBackend{Address: credhub.URL, Token: "wrong", Prefix: "/config-server"}
+
+			_, err := backend.Load()
+			Expect(err).To(MatchError(ContainSubstring("unexpected status 401 Unauthorized")))
+		})
+	})
+})
@@ -0,0 +1,114 @@
+package configserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Cache persists the last successfully loaded documents, encrypted, so
+// that the sidecar can serve them when its backends are down at start.
+type Cache struct {
+	Path   string
+	Cipher *Cipher
+}
+
+// cacheFile is the plaintext of a cache file.
+type cacheFile struct {
+	SavedAt time.Time      `json:"saved_at"`
+	Sources []*cacheSource `json:"sources"`
+}
+
+type cacheSource struct {
+	Name    string                 `json:"name"`
+	Origin  string                 `json:"origin"`
+	Data    map[string]interface{} `json:"data"`
+	Removed []string               `json:"removed,omitempty"`
+}
+
+// Save replaces the cache with sources, loaded at savedAt. The documents
+// are stored after variables are interpolated, so the whole file is
+// encrypted rather than just its {cipher} values.
+func (c *Cache) Save(sources []*Source, savedAt time.Time) error {
+	file := cacheFile{SavedAt: savedAt, Sources: make([]*cacheSource, len(sources))}
+	for i, source := range sources {
+		file.Sources[i] = &cacheSource{Name: source.Name, Origin: source.Origin, Data: source.Data, Removed: source.Removed}
+	}
+	return c.write(c.Path, file)
+}
+
+// Load returns the cached sources and when they were loaded.
+func (c *Cache) Load() ([]*Source, time.Time, error) {
+	var file cacheFile
+	if err := c.read(c.Path, &file); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	sources := make([]*Source, len(file.Sources))
+	for i, source := range file.Sources {
+		if source.Data == nil {
+			source.Data = map[string]interface{}{}
+		}
+		sources[i] = &Source{Name: source.Name, Origin: source.Origin, Data: source.Data, Removed: source.Removed}
+	}
+	return sources, file.SavedAt, nil
+}
+
+// historyPath is the file the history and pins of served documents are
+// kept in, next to the cache.
+func (c *Cache) historyPath() string {
+	return c.Path + ".history"
+}
+
+// write encrypts value as JSON to path.
+func (c *Cache) write(path string, value interface{}) error {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	encrypted, err := c.Cipher.Encrypt(string(js))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomically(path, []byte(encrypted+"\n"))
+}
+
+// read decrypts the JSON in path into value.
+func (c *Cache) read(path string, value interface{}) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read cache: %s", err)
+	}
+	js, err := c.Cipher.Decrypt(string(contents))
+	if err != nil {
+		return fmt.Errorf("unable to decrypt cache %s: %s", path, err)
+	}
+	if err := json.Unmarshal([]byte(js), value); err != nil {
+		return fmt.Errorf("unable to parse cache %s: %s", path, err)
+	}
+	return nil
+}
+
+// writeFileAtomically writes a file through a temporary file readable only
+// by the owner, so that a crash never leaves it truncated.
+func writeFileAtomically(path string, contents []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,151 @@
+package configserver_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		dir   string
+		cache *configserver.Cache
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "configserver")
+		Expect(err).NotTo(HaveOccurred())
+		cipher, err := configserver.NewCipher(testKey)
+		Expect(err).NotTo(HaveOccurred())
+		cache = &configserver.Cache{Path: filepath.Join(dir, "cache", "config.cache"), Cipher: cipher}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("saves the documents encrypted", func() {
+		savedAt := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+		sources := []*configserver.Source{
+			{Name: "billing", Origin: "vault:secret/data/billing", Data: map[string]interface{}{"password": "s3cret"}},
+		}
+		Expect(cache.Save(sources, savedAt)).To(Succeed())
+
+		contents, err := ioutil.ReadFile(cache.Path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(HavePrefix(configserver.CipherPrefix))
+		Expect(string(contents)).NotTo(ContainSubstring("s3cret"))
+
+		loaded, loadedAt, err := cache.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded).To(Equal(sources))
+		Expect(loadedAt.Equal(savedAt)).To(BeTrue())
+
+		cache.Cipher, _ = configserver.NewCipher(otherKey)
+		_, _, err = cache.Load()
+		Expect(err).To(MatchError(ContainSubstring("unable to decrypt cache")))
+	})
+
+	Describe("serving", func() {
+		var (
+			backend *flakyBackend
+			server  *configserver.Server
+		)
+
+		get := func(path string) *httptest.ResponseRecorder {
+			res := httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+			return res
+		}
+
+		BeforeEach(func() {
+			backend = &flakyBackend{sources: []*configserver.Source{
+				{Name: "billing", Origin: "flaky:billing", Data: map[string]interface{}{"queue": "billing"}},
+			}}
+			newServer := func() *configserver.Server {
+				server := configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+				server.Cache = cache
+				return server
+			}
+
+			// A first run caches the documents, then the sidecar restarts
+			// with the backend down.
+			Expect(newServer().Load()).To(Succeed())
+			backend.loadErr = errors.New("connection refused")
+			server = newServer()
+		})
+
+		AfterEach(func() {
+			server.CloseStreams()
+		})
+
+		It("fails fast by default", func() {
+			Expect(server.Load()).To(MatchError("flaky backend: connection refused"))
+			Expect(get("/config/billing").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("serves the cached documents as stale", func() {
+			server.ServeStale = true
+			Expect(server.Load()).To(Succeed())
+
+			res := get("/config/billing")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("X-Config-Stale")).To(Equal("true"))
+			Expect(res.Body.String()).To(MatchJSON(`{"queue":"billing"}`))
+			Expect(get("/billing/default").Header().Get("X-Config-Stale")).To(Equal("true"))
+			Expect(server.Status().Stale).To(BeTrue())
+			Expect(server.Status().Ready).To(BeFalse())
+
+			backend.heal(backend.sources)
+			Expect(server.Load()).To(Succeed())
+			Expect(get("/config/billing").Header()).NotTo(HaveKey("X-Config-Stale"))
+			Expect(server.Status().Stale).To(BeFalse())
+		})
+
+		It("reloads the backends in the background until they recover", func() {
+			server.ServeStale = true
+			server.RetryInterval = 10 * time.Millisecond
+			Expect(server.Load()).To(Succeed())
+			Consistently(server.Stale, 100*time.Millisecond).Should(BeTrue())
+
+			backend.heal([]*configserver.Source{
+				{Name: "billing", Origin: "flaky:billing", Data: map[string]interface{}{"queue": "billing-v2"}},
+			})
+			Eventually(server.Stale).Should(BeFalse())
+			Expect(server.Status().Ready).To(BeTrue())
+
+			res := get("/config/billing")
+			Expect(res.Header()).NotTo(HaveKey("X-Config-Stale"))
+			Expect(res.Body.String()).To(MatchJSON(`{"queue":"billing-v2"}`))
+		})
+
+		It("fails fast when a file is broken", func() {
+			backend.heal(backend.sources)
+			broken := filepath.Join(dir, "broken.yml")
+			Expect(ioutil.WriteFile(broken, []byte("queue: [billing\n"), 0644)).To(Succeed())
+			server = configserver.NewServer([]configserver.Backend{backend, &configserver.FileBackend{Patterns: []string{broken}}}, log.New(GinkgoWriter, "", 0))
+			server.Cache = cache
+			server.ServeStale = true
+
+			Expect(server.Load()).To(MatchError(HavePrefix("file backend: ")))
+			Expect(get("/config/billing").Code).To(Equal(http.StatusNotFound))
+			Expect(server.Status().Stale).To(BeFalse())
+		})
+
+		It("fails when there is no cache to serve", func() {
+			server.ServeStale = true
+			Expect(os.Remove(cache.Path)).To(Succeed())
+			Expect(server.Load()).To(MatchError(ContainSubstring("flaky backend: connection refused, and no cached config can be served")))
+		})
+	})
+})
@@ -0,0 +1,138 @@
+package configserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// CipherPrefix marks an encrypted value in a config document.
+const CipherPrefix = "{cipher}"
+
+// Cipher encrypts and decrypts {cipher} values with AES-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a cipher for a base64 encoded AES key of 16, 24 or 32
+// bytes, e.g. one generated with `openssl rand -base64 32`.
+func NewCipher(encodedKey string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %s", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes: %s", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// LoadCipher returns the cipher configured by settings, reading the key file
+// if one is set. It returns nil if no key is configured.
+func LoadCipher(settings Settings) (*Cipher, error) {
+	key := settings.EncryptKey
+	if key == "" && settings.EncryptKeyFile != "" {
+		contents, err := ioutil.ReadFile(settings.EncryptKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read encryption key: %s", err)
+		}
+		key = string(contents)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	return NewCipher(key)
+}
+
+// Encrypt returns the {cipher} value for plaintext.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return CipherPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of a {cipher} value. The prefix is optional.
+func (c *Cipher) Decrypt(value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, CipherPrefix))
+	if err != nil {
+		return "", fmt.Errorf("ciphertext is not valid base64: %s", err)
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("unable to decrypt value: wrong key or corrupted ciphertext")
+	}
+	return string(plaintext), nil
+}
+
+// DecryptDocument returns a copy of document with every {cipher} string
+// replaced by its plaintext. A nil cipher fails if any value is encrypted.
+func (c *Cipher) DecryptDocument(document map[string]interface{}) (map[string]interface{}, error) {
+	decrypted, err := c.decryptValue("", document)
+	if err != nil {
+		return nil, err
+	}
+	return decrypted.(map[string]interface{}), nil
+}
+
+func (c *Cipher) decryptValue(path string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			decrypted, err := c.decryptValue(itemPath, item)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = decrypted
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			decrypted, err := c.decryptValue(fmt.Sprintf("%s[%d]", path, i), item)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = decrypted
+		}
+		return s, nil
+	case string:
+		if !strings.HasPrefix(v, CipherPrefix) {
+			return v, nil
+		}
+		if c == nil {
+			return nil, fmt.Errorf("%s is encrypted but no encryption key is configured", path)
+		}
+		plaintext, err := c.Decrypt(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return plaintext, nil
+	default:
+		return v, nil
+	}
+}
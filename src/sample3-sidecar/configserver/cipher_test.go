@@ -0,0 +1,113 @@
+package configserver_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	testKey  = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	otherKey = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+var _ = Describe("Cipher", func() {
+	var cipher *configserver.Cipher
+
+	BeforeEach(func() {
+		var err error
+		cipher, err = configserver.NewCipher(testKey)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("round trips values through the {cipher} form", func() {
+		encrypted, err := cipher.Encrypt("not-a-real-p4$$w0rd")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encrypted).To(HavePrefix("{cipher}"))
+		Expect(encrypted).NotTo(ContainSubstring("p4$$w0rd"))
+
+		Expect(cipher.Decrypt(encrypted)).To(Equal("not-a-real-p4$$w0rd"))
+	})
+
+	It("fails to decrypt with the wrong key", func() {
+		encrypted, err := cipher.Encrypt("secret")
+		Expect(err).NotTo(HaveOccurred())
+
+		other, err := configserver.NewCipher(otherKey)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = other.Decrypt(encrypted)
+		Expect(err).To(MatchError(ContainSubstring("wrong key")))
+	})
+
+	It("rejects keys of the wrong size", func() {
+		_, err := configserver.NewCipher("c2hvcnQ=")
+		Expect(err).To(MatchError(ContainSubstring("must be 16, 24 or 32 bytes")))
+	})
+
+	It("reads the key from a file", func() {
+		file, err := ioutil.TempFile("", "key")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(testKey + "\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+
+		loaded, err := configserver.LoadCipher(configserver.Settings{EncryptKeyFile: file.Name()})
+		Expect(err).NotTo(HaveOccurred())
+		encrypted, err := cipher.Encrypt("secret")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded.Decrypt(encrypted)).To(Equal("secret"))
+	})
+
+	It("returns no cipher when no key is configured", func() {
+		Expect(configserver.LoadCipher(configserver.Settings{})).To(BeNil())
+	})
+
+	Describe("DecryptDocument", func() {
+		It("decrypts nested values without modifying the input", func() {
+			encrypted, err := cipher.Encrypt("secret")
+			Expect(err).NotTo(HaveOccurred())
+			document := map[string]interface{}{
+				"db":    map[string]interface{}{"password": encrypted, "port": 5432},
+				"hosts": []interface{}{encrypted, "plain"},
+			}
+
+			Expect(cipher.DecryptDocument(document)).To(Equal(map[string]interface{}{
+				"db":    map[string]interface{}{"password": "secret", "port": 5432},
+				"hosts": []interface{}{"secret", "plain"},
+			}))
+			Expect(document["db"]).To(HaveKeyWithValue("password", encrypted))
+		})
+
+		It("fails with the key path when no cipher is configured", func() {
+			var none *configserver.Cipher
+			_, err := none.DecryptDocument(map[string]interface{}{
+				"hosts": []interface{}{"{cipher}abc"},
+			})
+			Expect(err).To(MatchError("hosts[0] is encrypted but no encryption key is configured"))
+		})
+	})
+
+	It("is applied by the server when serving documents", func() {
+		encrypted, err := cipher.Encrypt("not-a-real-p4$$w0rd")
+		Expect(err).NotTo(HaveOccurred())
+		server := newServer(&configserver.Source{Name: "application", Origin: "test", Data: map[string]interface{}{"Password": encrypted}})
+
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, httptest.NewRequest("GET", "/config/", nil))
+		Expect(res.Code).To(Equal(http.StatusInternalServerError))
+
+		server.Cipher = cipher
+		for _, path := range []string{"/config/", "/application-default.json", "/application/default"} {
+			res = httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(ContainSubstring(`"Password":"not-a-real-p4$$w0rd"`))
+		}
+	})
+})
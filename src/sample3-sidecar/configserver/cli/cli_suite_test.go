@@ -0,0 +1,72 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+
+	"testing"
+)
+
+func TestCli(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config Server Cli Suite")
+}
+
+var configServer string
+
+var _ = BeforeSuite(func() {
+	var err error
+	configServer, err = gexec.Build("sample3-sidecar/configserver/cli")
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
+
+// app is an app directory holding config files, the $HOME config-server
+// runs in.
+type app struct {
+	dir string
+	env []string
+}
+
+func newApp(files map[string]string) *app {
+	dir, err := ioutil.TempDir("", "configserver-cli")
+	Expect(err).NotTo(HaveOccurred())
+	a := &app{dir: dir, env: []string{"HOME=" + dir, "PATH=" + os.Getenv("PATH")}}
+	for name, contents := range files {
+		a.write(name, contents)
+	}
+	return a
+}
+
+func (a *app) write(name, contents string) string {
+	path := filepath.Join(a.dir, name)
+	Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+	Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	return path
+}
+
+func (a *app) remove() {
+	Expect(os.RemoveAll(a.dir)).To(Succeed())
+}
+
+// run runs config-server with args and the app's environment plus env,
+// feeding it stdin, and returns the finished session.
+func (a *app) run(stdin string, env []string, args ...string) *gexec.Session {
+	cmd := exec.Command(configServer, args...)
+	cmd.Dir = a.dir
+	cmd.Env = append(append([]string{}, a.env...), env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+	Expect(err).NotTo(HaveOccurred())
+	return session.Wait()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sample3-sidecar/configserver"
+	"strings"
+
+	"github.com/google/subcommands"
+)
+
+type encryptCmd struct {
+	keyFile string
+}
+
+func (*encryptCmd) Name() string     { return "encrypt" }
+func (*encryptCmd) Synopsis() string { return "Encrypt a value for use in a config file." }
+func (*encryptCmd) Usage() string {
+	return `encrypt [-key-file <path>] [<value>]:
+  Print the {cipher} form of value, read from stdin when omitted. The key is
+  read from -key-file, $CONFIG_SERVER_ENCRYPT_KEY or $CONFIG_SERVER_ENCRYPT_KEY_FILE.
+`
+}
+func (c *encryptCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.keyFile, "key-file", "", "file containing the base64 encoded encryption key")
+}
+
+func (c *encryptCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	return runCipher(c.keyFile, f, (*configserver.Cipher).Encrypt)
+}
+
+type decryptCmd struct {
+	keyFile string
+}
+
+func (*decryptCmd) Name() string     { return "decrypt" }
+func (*decryptCmd) Synopsis() string { return "Decrypt a {cipher} value." }
+func (*decryptCmd) Usage() string {
+	return `decrypt [-key-file <path>] [<value>]:
+  Print the plaintext of a {cipher} value, read from stdin when omitted. The key
+  is read from -key-file, $CONFIG_SERVER_ENCRYPT_KEY or $CONFIG_SERVER_ENCRYPT_KEY_FILE.
+`
+}
+func (c *decryptCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.keyFile, "key-file", "", "file containing the base64 encoded encryption key")
+}
+
+func (c *decryptCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	return runCipher(c.keyFile, f, (*configserver.Cipher).Decrypt)
+}
+
+func runCipher(keyFile string, f *flag.FlagSet, transform func(*configserver.Cipher, string) (string, error)) subcommands.ExitStatus {
+	if f.NArg() > 1 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	settings, err := configserver.NewSettings(os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	if keyFile != "" {
+		settings.EncryptKey, settings.EncryptKeyFile = "", keyFile
+	}
+	cipher, err := configserver.LoadCipher(settings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	if cipher == nil {
+		fmt.Fprintln(os.Stderr, "Error: missing $CONFIG_SERVER_ENCRYPT_KEY or $CONFIG_SERVER_ENCRYPT_KEY_FILE")
+		return subcommands.ExitFailure
+	}
+
+	value := f.Arg(0)
+	if f.NArg() == 0 {
+		// Read all of stdin, so that multi-line values such as PEM keys
+		// are kept whole, dropping only the newline ending the last line.
+		in, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: unable to read value from stdin: %s\n", err)
+			return subcommands.ExitFailure
+		}
+		value = strings.TrimSuffix(strings.TrimSuffix(string(in), "\n"), "\r")
+	}
+
+	result, err := transform(cipher, value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	fmt.Println(result)
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sample3-sidecar/configserver"
+
+	"github.com/google/subcommands"
+)
+
+type explainCmd struct {
+	loadFlags
+	json bool
+}
+
+func (*explainCmd) Name() string     { return "explain" }
+func (*explainCmd) Synopsis() string { return "Show where each key of a document comes from." }
+func (*explainCmd) Usage() string {
+	return `explain [-ops <file>]... [-space <space>] [-json] [<name> [<profile>]]:
+  Load the configured backends and print, for each key of the document for
+  name, application when omitted, the layer and origin that set it and the
+  layers it overrides, after the layers in order of precedence.
+`
+}
+func (c *explainCmd) SetFlags(f *flag.FlagSet) {
+	c.loadFlags.SetFlags(f)
+	f.BoolVar(&c.json, "json", false, "print the explanation as served by ?explain=true")
+}
+
+func (c *explainCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if f.NArg() > 2 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	server, err := c.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	name := configserver.DefaultName
+	if f.NArg() > 0 {
+		name = f.Arg(0)
+	}
+	explanation, found := configserver.Explain(server.Sources(), name, profilesArg(f))
+	if !found {
+		fmt.Fprintf(os.Stderr, "Error: config %q not found\n", name)
+		return subcommands.ExitFailure
+	}
+
+	if c.json {
+		err = json.NewEncoder(os.Stdout).Encode(explanation)
+	} else {
+		err = explanation.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
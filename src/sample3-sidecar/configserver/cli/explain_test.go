@@ -0,0 +1,65 @@
+package main_test
+
+import (
+	"encoding/json"
+	"regexp"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("explain", func() {
+	var a *app
+
+	BeforeEach(func() {
+		a = newApp(map[string]string{
+			"config/application.yml": "log:\n  level: info\n",
+			"config/billing.yml":     "db:\n  pool: 5\n  host: db\n",
+		})
+	})
+
+	AfterEach(func() {
+		a.remove()
+	})
+
+	It("gives the keys changed by -ops the origin of each op", func() {
+		ops := a.write("ops/prod.yml", "- type: replace\n  path: /billing/db/pool\n  value: 20\n- type: remove\n  path: /billing/db/host\n")
+
+		session := a.run("", nil, "explain", "-ops", ops, "billing")
+		Expect(session).To(gexec.Exit(0))
+		out := string(session.Out.Contents())
+		origin := regexp.QuoteMeta("ops:" + ops)
+		Expect(out).To(MatchRegexp(`(?m)^db\.pool +billing +` + origin + `:/billing/db/pool +billing$`))
+		Expect(out).To(MatchRegexp(`(?m)^log\.level +application +file:.*/config/application\.yml *$`))
+		Expect(out).To(MatchRegexp(`(?m)^REMOVED KEY +LAYER +ORIGIN\ndb\.host +billing +` + origin + `:/billing/db/host$`))
+	})
+
+	It("prints the explanation served by ?explain=true with -json", func() {
+		session := a.run("", nil, "explain", "-json", "billing")
+		Expect(session).To(gexec.Exit(0))
+
+		var explanation configserver.Explanation
+		Expect(json.Unmarshal(session.Out.Contents(), &explanation)).To(Succeed())
+		Expect(explanation.Name).To(Equal("billing"))
+		Expect(explanation.Precedence).To(HaveLen(2))
+		Expect(explanation.Keys).To(HaveLen(3))
+		Expect(explanation.Keys["db.pool"].Name).To(Equal("billing"))
+		Expect(explanation.Keys["log.level"].Name).To(Equal("application"))
+	})
+
+	DescribeTable("fails",
+		func(status int, stderr string, args ...string) {
+			session := a.run("", nil, append([]string{"explain"}, args...)...)
+			Expect(session).To(gexec.Exit(status))
+			Expect(session.Err).To(gbytes.Say(stderr))
+			Expect(session.Out.Contents()).To(BeEmpty())
+		},
+		Entry("with more than a name and profiles", 2, `explain \[-ops <file>\]`, "billing", "prod", "extra"),
+		Entry("with unknown flags", 2, `flag provided but not defined: -format`, "-format", "json", "billing"),
+		Entry("on unknown documents", 1, `Error: config "missing" not found`, "missing"),
+	)
+})
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/google/subcommands"
+)
+
+func main() {
+	subcommands.Register(subcommands.HelpCommand(), "")
+	subcommands.Register(subcommands.FlagsCommand(), "")
+	subcommands.Register(subcommands.CommandsCommand(), "")
+	subcommands.Register(&serveCmd{}, "")
+	subcommands.Register(&encryptCmd{}, "")
+	subcommands.Register(&decryptCmd{}, "")
+	subcommands.Register(&renderCmd{}, "")
+	subcommands.Register(&explainCmd{}, "")
+
+	// The sidecar is started as a bare `config-server`, so serve by default.
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	flag.CommandLine.Parse(args)
+
+	os.Exit(int(subcommands.Execute(context.Background())))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sample3-sidecar/configserver"
+	"strings"
+
+	"github.com/google/subcommands"
+)
+
+type renderCmd struct {
+	loadFlags
+	format  string
+	decrypt bool
+}
+
+func (*renderCmd) Name() string     { return "render" }
+func (*renderCmd) Synopsis() string { return "Print a document as the sidecar would serve it." }
+func (*renderCmd) Usage() string {
+	return `render [-ops <file>]... [-space <space>] [-format <format>] [-decrypt] [<name> [<profile>]]:
+  Load the configured backends, apply the ops files and variables, and print
+  the document for name, application when omitted. -ops replaces
+  $CONFIG_SERVER_OPS_FILES and -space the space read from $VCAP_APPLICATION.
+  Encrypted values are printed as they are unless -decrypt is given.
+`
+}
+func (c *renderCmd) SetFlags(f *flag.FlagSet) {
+	c.loadFlags.SetFlags(f)
+	f.StringVar(&c.format, "format", "yaml", "output format: json, yaml, properties or dotenv")
+	f.BoolVar(&c.decrypt, "decrypt", false, "decrypt {cipher} values")
+}
+
+func (c *renderCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if f.NArg() > 2 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	var format *configserver.Format
+	for _, candidate := range configserver.Formats {
+		if candidate.Name == c.format {
+			format = candidate
+		}
+	}
+	if format == nil {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q\n", c.format)
+		return subcommands.ExitUsageError
+	}
+
+	server, err := c.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	name := configserver.DefaultName
+	if f.NArg() > 0 {
+		name = f.Arg(0)
+	}
+	sources, found := configserver.Resolve(server.Sources(), name, profilesArg(f))
+	if !found {
+		fmt.Fprintf(os.Stderr, "Error: config %q not found\n", name)
+		return subcommands.ExitFailure
+	}
+
+	document := configserver.MergeSources(sources)
+	if c.decrypt {
+		if document, err = server.Cipher.DecryptDocument(document); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+	if err := format.Write(os.Stdout, document); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// loadFlags are the flags of commands that load the documents locally.
+type loadFlags struct {
+	ops   listFlag
+	space string
+}
+
+func (l *loadFlags) SetFlags(f *flag.FlagSet) {
+	f.Var(&l.ops, "ops", "ops file to apply, may be repeated")
+	f.StringVar(&l.space, "space", "", "space whose ops files are applied")
+}
+
+// load returns a server holding the documents loaded from the configured
+// backends.
+func (l *loadFlags) load() (*configserver.Server, error) {
+	settings, err := configserver.NewSettings(l.getenv)
+	if err != nil {
+		return nil, err
+	}
+	server, err := newServer(settings, log.New(ioutil.Discard, "", 0), false)
+	if err != nil {
+		return nil, err
+	}
+	return server, server.Load()
+}
+
+// getenv reads the environment with the ops files and space given on the
+// command line in place of their variables.
+func (l *loadFlags) getenv(name string) string {
+	switch {
+	case name == "CONFIG_SERVER_OPS_FILES" && len(l.ops) > 0:
+		return strings.Join(l.ops, ",")
+	case name == "VCAP_APPLICATION" && l.space != "":
+		vcap, _ := json.Marshal(map[string]string{"space_name": l.space})
+		return string(vcap)
+	}
+	return os.Getenv(name)
+}
+
+// listFlag collects the values of a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// profilesArg returns the comma separated profiles in the second argument.
+func profilesArg(f *flag.FlagSet) []string {
+	if f.NArg() < 2 {
+		return nil
+	}
+	return strings.Split(f.Arg(1), ",")
+}
@@ -0,0 +1,85 @@
+package main_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("render", func() {
+	var a *app
+
+	BeforeEach(func() {
+		a = newApp(map[string]string{
+			"config/application.yml":  "log:\n  level: info\n",
+			"config/billing.yml":      "db:\n  pool: 5\n",
+			"config/billing-prod.yml": "db:\n  pool: 10\n",
+			"ops/prod.yml":            "- type: replace\n  path: /billing/db/pool\n  value: 20\n",
+			"ops/spaces/staging.yml":  "- type: replace\n  path: /application/log/level\n  value: debug\n",
+		})
+	})
+
+	AfterEach(func() {
+		a.remove()
+	})
+
+	DescribeTable("prints documents",
+		func(out string, args ...string) {
+			session := a.run("", nil, append([]string{"render"}, args...)...)
+			Expect(session).To(gexec.Exit(0))
+			Expect(string(session.Out.Contents())).To(Equal(out))
+		},
+		Entry("as YAML by default", "log:\n  level: info\n"),
+		Entry("by name", "db:\n  pool: 5\nlog:\n  level: info\n", "billing"),
+		Entry("with profiles", "db:\n  pool: 10\nlog:\n  level: info\n", "billing", "prod"),
+		Entry("as JSON", `{"db":{"pool":10},"log":{"level":"info"}}`+"\n", "-format", "json", "billing", "prod"),
+		Entry("as properties", "db.pool: 10\nlog.level: info\n", "-format", "properties", "billing", "prod"),
+		Entry("as dotenv", "DB_POOL=\"10\"\nLOG_LEVEL=\"info\"\n", "-format", "dotenv", "billing", "prod"),
+		Entry("with ops files", "db:\n  pool: 20\nlog:\n  level: info\n", "-ops", "ops/prod.yml", "billing"),
+		Entry("with the ops files of a space", "log:\n  level: debug\n", "-ops", "ops/spaces/*.yml", "-space", "staging"),
+	)
+
+	It("decrypts values with -decrypt", func() {
+		env := []string{"CONFIG_SERVER_ENCRYPT_KEY=" + encryptKey}
+		session := a.run("", env, "encrypt", "s3cret")
+		Expect(session).To(gexec.Exit(0))
+		a.write("config/billing.yml", "db:\n  password: '"+strings.TrimSpace(string(session.Out.Contents()))+"'\n")
+
+		session = a.run("", env, "render", "billing")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`password: '\{cipher\}`))
+
+		session = a.run("", env, "render", "-decrypt", "billing")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`password: s3cret\n`))
+	})
+
+	It("validates encrypted values without a key", func() {
+		session := a.run("", []string{"CONFIG_SERVER_ENCRYPT_KEY=" + encryptKey}, "encrypt", "s3cret")
+		Expect(session).To(gexec.Exit(0))
+		a.write("config/billing.yml", "db:\n  password: '"+strings.TrimSpace(string(session.Out.Contents()))+"'\n")
+		a.write("config/schemas/billing.yml", "properties:\n  db:\n    properties:\n      password: {type: string}\n")
+
+		session = a.run("", nil, "render", "billing")
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`password: '\{cipher\}`))
+	})
+
+	DescribeTable("fails",
+		func(status int, stderr string, args ...string) {
+			session := a.run("", nil, append([]string{"render"}, args...)...)
+			Expect(session).To(gexec.Exit(status))
+			Expect(session.Err).To(gbytes.Say(stderr))
+			Expect(session.Out.Contents()).To(BeEmpty())
+		},
+		Entry("with more than a name and profiles", 2, `render \[-ops <file>\]`, "billing", "prod", "extra"),
+		Entry("with an unknown format", 2, `Error: unknown format "xml"`, "-format", "xml", "billing"),
+		Entry("with unknown flags", 2, `flag provided but not defined: -profile`, "-profile", "prod", "billing"),
+		Entry("on unknown documents", 1, `Error: config "missing" not found`, "missing"),
+		Entry("when an ops file fails", 1, `Error: `, "-ops", "ops/missing.yml", "billing"),
+	)
+})
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sample3-sidecar/configserver"
+	"syscall"
+
+	"github.com/google/subcommands"
+)
+
+type serveCmd struct{}
+
+func (*serveCmd) Name() string     { return "serve" }
+func (*serveCmd) Synopsis() string { return "Serve configuration to the app (default)." }
+func (*serveCmd) Usage() string {
+	return `serve:
+  Serve configuration on localhost:$CONFIG_SERVER_PORT, or on the Unix socket
+  at $CONFIG_SERVER_SOCKET when it is set.
+`
+}
+func (*serveCmd) SetFlags(f *flag.FlagSet) {}
+
+func (*serveCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+
+	settings, err := configserver.NewSettings(os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	if settings.Port == "" && settings.Socket == "" {
+		fmt.Fprintln(os.Stderr, "Error: missing $CONFIG_SERVER_PORT or $CONFIG_SERVER_SOCKET")
+		return subcommands.ExitFailure
+	}
+
+	server, err := newServer(settings, logger, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	server.Token = settings.Token
+	server.Heartbeat = settings.Heartbeat
+	server.HistorySize = settings.HistorySize
+	if server.Policy, err = configserver.LoadPolicy(settings, os.Getenv); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	if server.Cache, err = newCache(settings, server.Cipher); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	server.ServeStale = settings.ServeStale
+	if server.Policy != nil {
+		logger.Printf("Restricting access to config with the policy in %s", settings.PolicyFile)
+	} else if server.Token == "" {
+		logger.Println("Warning: $CONFIG_SERVER_TOKEN is not set, requests will not be authenticated")
+	}
+	if err := server.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	if settings.Watch {
+		go func() {
+			if err := server.Watch(nil, settings.ReloadDelay); err != nil {
+				logger.Printf("Unable to watch config files, changes will need a POST to /refresh: %s", err)
+			}
+		}()
+	}
+
+	listener, err := configserver.Listen(settings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	fmt.Println("listening " + listener.Addr().String() + "...")
+	err = configserver.Serve(listener, server.Handler(), signals, settings.DrainWindow, settings.ShutdownTimeout, logger, server.CloseStreams)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// newServer returns a server for the backends, key, ops files, variables
+// and schemas in settings. The caller sets the remaining fields and loads it.
+// Unless generate is set, declared variables are only read from the vars
+// store and never generated, so that inspecting config writes no
+// credentials.
+func newServer(settings configserver.Settings, logger *log.Logger, generate bool) (*configserver.Server, error) {
+	backends, err := configserver.NewBackends(settings)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := configserver.LoadCipher(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	server := configserver.NewServer(backends, logger)
+	server.Cipher = cipher
+	server.Services = settings.Services
+	if len(settings.OpsFiles) > 0 {
+		server.Ops = &configserver.OpsFiles{Patterns: settings.OpsFiles}
+	}
+	server.Schemas = &configserver.Schemas{Dir: settings.SchemaDir}
+	server.Interpolator = configserver.NewInterpolator(settings, backends)
+	store, err := configserver.NewVarsStore(settings, backends)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil && !generate {
+		store = configserver.ReadOnlyVarsStore(store)
+	}
+	server.Interpolator.VarsStore = store
+	return server, nil
+}
+
+// newCache returns the cache configured by settings, encrypted with the
+// cache key or else with cipher. It returns nil when no cache file is set.
+func newCache(settings configserver.Settings, cipher *configserver.Cipher) (*configserver.Cache, error) {
+	if settings.CacheFile == "" {
+		return nil, nil
+	}
+	if settings.CacheKey != "" {
+		var err error
+		if cipher, err = configserver.NewCipher(settings.CacheKey); err != nil {
+			return nil, fmt.Errorf("invalid $CONFIG_SERVER_CACHE_KEY: %s", err)
+		}
+	}
+	return &configserver.Cache{Path: settings.CacheFile, Cipher: cipher}, nil
+}
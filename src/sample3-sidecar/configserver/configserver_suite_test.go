@@ -0,0 +1,33 @@
+package configserver_test
+
+import (
+	"log"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestConfigserver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Configserver Suite")
+}
+
+// staticBackend serves a fixed set of sources.
+type staticBackend []*configserver.Source
+
+func (b staticBackend) Name() string {
+	return "static"
+}
+
+func (b staticBackend) Load() ([]*configserver.Source, error) {
+	return b, nil
+}
+
+func newServer(sources ...*configserver.Source) *configserver.Server {
+	server := configserver.NewServer([]configserver.Backend{staticBackend(sources)}, log.New(GinkgoWriter, "", 0))
+	Expect(server.Load()).To(Succeed())
+	return server
+}
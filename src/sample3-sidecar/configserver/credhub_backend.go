@@ -0,0 +1,150 @@
+package configserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultCredHubPrefix is the credential path searched by the CredHub
+// backend when none is configured.
+const DefaultCredHubPrefix = "/config-server"
+
+// CredHubBackend loads documents from CredHub. Following the Spring Cloud
+// Config CredHub layout, the credential
+//
+//	<Prefix>/<name>/<profile>/<key>[/<key>...]
+//
+// sets key in the <name>-<profile> document, or in the <name> document
+// when the profile is "default".
+type CredHubBackend struct {
+	Address string
+	Token   string
+	Prefix  string
+	Client  *http.Client
+}
+
+func (b *CredHubBackend) Name() string {
+	return "credhub"
+}
+
+func (b *CredHubBackend) Remote() bool {
+	return true
+}
+
+func (b *CredHubBackend) Load() ([]*Source, error) {
+	prefix := "/" + trimSlashes(b.Prefix)
+
+	var found struct {
+		Credentials []struct {
+			Name string `json:"name"`
+		} `json:"credentials"`
+	}
+	ok, err := b.get(url.Values{"path": {prefix}}, &found)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	documents := map[string]map[string]interface{}{}
+	origins := map[string]string{}
+	for _, credential := range found.Credentials {
+		parts := strings.Split(trimSlashes(strings.TrimPrefix(credential.Name, prefix)), "/")
+		if len(parts) < 3 {
+			continue
+		}
+
+		var current struct {
+			Data []struct {
+				Value interface{} `json:"value"`
+			} `json:"data"`
+		}
+		ok, err := b.get(url.Values{"name": {credential.Name}, "current": {"true"}}, &current)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || len(current.Data) == 0 {
+			continue
+		}
+
+		name := parts[0]
+		if parts[1] != "default" {
+			name += "-" + parts[1]
+		}
+		if documents[name] == nil {
+			documents[name] = map[string]interface{}{}
+			origins[name] = "credhub:" + prefix + "/" + parts[0] + "/" + parts[1]
+		}
+		setNested(documents[name], parts[2:], normalize(current.Data[0].Value))
+	}
+
+	return sortedSources(documents, func(name string) string {
+		return origins[name]
+	}), nil
+}
+
+// Variable resolves ((name)) from the current value of a credential.
+// Absolute names are used as they are, and relative names are looked up
+// under Prefix.
+func (b *CredHubBackend) Variable(name string) (interface{}, bool, error) {
+	name = b.credentialName(name)
+
+	var current struct {
+		Data []struct {
+			Value interface{} `json:"value"`
+		} `json:"data"`
+	}
+	ok, err := b.get(url.Values{"name": {name}, "current": {"true"}}, &current)
+	if err != nil || !ok || len(current.Data) == 0 {
+		return nil, false, err
+	}
+	return normalize(current.Data[0].Value), true, nil
+}
+
+// SetVariable stores value as a json credential when it is a mapping, and
+// as a value credential otherwise, in the no-overwrite mode that keeps an
+// existing credential.
+func (b *CredHubBackend) SetVariable(name string, value interface{}) error {
+	kind := "value"
+	if _, ok := normalize(value).(map[string]interface{}); ok {
+		kind = "json"
+	}
+	body := map[string]interface{}{
+		"name":  b.credentialName(name),
+		"type":  kind,
+		"value": normalize(value),
+		"mode":  "no-overwrite",
+	}
+	header := http.Header{}
+	if b.Token != "" {
+		header.Set("Authorization", "Bearer "+b.Token)
+	}
+	return sendJSON(b.Client, "PUT", strings.TrimSuffix(b.Address, "/")+"/api/v1/data", header, body)
+}
+
+func (b *CredHubBackend) credentialName(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return name
+	}
+	return "/" + trimSlashes(b.Prefix) + "/" + name
+}
+
+// Check reports whether CredHub is reachable.
+func (b *CredHubBackend) Check() error {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(b.Address, "/")+"/health", nil)
+	if err != nil {
+		return err
+	}
+	return checkHealth(b.Client, req)
+}
+
+func (b *CredHubBackend) get(query url.Values, out interface{}) (bool, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(b.Address, "/")+"/api/v1/data?"+query.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	if b.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.Token)
+	}
+
+	return getJSON(b.Client, req, out)
+}
@@ -0,0 +1,59 @@
+package configserver
+
+import (
+	"os"
+	"strings"
+)
+
+// DefaultEnvPrefix is the prefix of variables read by the env backend.
+const DefaultEnvPrefix = "CONFIG_DOC_"
+
+// EnvBackend loads documents from environment variables of the form
+//
+//	<PREFIX><NAME>__<KEY>[__<KEY>...]=value
+//
+// so CONFIG_DOC_BILLING_PROD__DB__URL sets db.url in the billing-prod
+// document. Names and keys are lower-cased; underscores in the name become
+// hyphens and double underscores in the key separate nested keys.
+type EnvBackend struct {
+	Prefix string
+	// Environ returns the environment, defaulting to os.Environ.
+	Environ func() []string
+}
+
+func (b *EnvBackend) Name() string {
+	return "env"
+}
+
+func (b *EnvBackend) Load() ([]*Source, error) {
+	environ := b.Environ
+	if environ == nil {
+		environ = os.Environ
+	}
+	prefix := b.Prefix
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+
+	documents := map[string]map[string]interface{}{}
+	for _, entry := range environ() {
+		i := strings.Index(entry, "=")
+		if i < 0 || !strings.HasPrefix(entry[:i], prefix) {
+			continue
+		}
+
+		parts := strings.Split(strings.ToLower(entry[len(prefix):i]), "__")
+		if len(parts) < 2 || parts[0] == "" {
+			continue
+		}
+		name := strings.Replace(parts[0], "_", "-", -1)
+		if documents[name] == nil {
+			documents[name] = map[string]interface{}{}
+		}
+		setNested(documents[name], parts[1:], entry[i+1:])
+	}
+
+	return sortedSources(documents, func(name string) string {
+		return "env:" + prefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+	}), nil
+}
@@ -0,0 +1,132 @@
+package configserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHeartbeat is how often an idle watch stream sends a comment line,
+// so that proxies and clients can tell it is still alive.
+const DefaultHeartbeat = 15 * time.Second
+
+// DocumentVersion returns a stable hash of a document's contents.
+func DocumentVersion(document map[string]interface{}) (string, error) {
+	js, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return jsonVersion(js), nil
+}
+
+// jsonVersion hashes a marshalled document. encoding/json sorts map keys,
+// so equal documents marshal, and hash, identically.
+func jsonVersion(js []byte) string {
+	sum := sha256.Sum256(js)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ConfigEvent is the data of a config event sent on a watch stream.
+type ConfigEvent struct {
+	Version  string                 `json:"version"`
+	Document map[string]interface{} `json:"document"`
+}
+
+// CloseStreams ends every open watch stream. Streams never become idle, so
+// they must be closed for the HTTP server to shut down.
+func (s *Server) CloseStreams() {
+	s.closeStreams.Do(func() {
+		close(s.closing)
+	})
+}
+
+// updates returns a channel that is closed on the next successful Load.
+func (s *Server) updates() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.updated
+}
+
+// watch serves /config/<name>[/<profile>]/watch as a stream of Server-Sent
+// Events. A "config" event carrying the document and its version is sent
+// when the stream opens and whenever the resolved document changes. The
+// version is the event id, so a client reconnecting with the
+// Last-Event-ID header only receives an event if the document changed
+// while it was away.
+func (s *Server) watch(res http.ResponseWriter, req *http.Request, name string, profiles []string) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		http.Error(res, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	if _, found := s.resolve(name, profiles); !found {
+		s.Log.Printf("Received a watch request for unknown config %q.", name)
+		http.Error(res, fmt.Sprintf("config %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	heartbeat := s.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = DefaultHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.Log.Printf("Opened a watch stream for config %q.", name)
+	defer s.Log.Printf("Closed a watch stream for config %q.", name)
+
+	last := req.Header.Get("Last-Event-ID")
+	for {
+		// Subscribe before resolving, so a Load in between is not missed.
+		updated := s.updates()
+
+		resolved, found, err := s.resolveDocument(name, profiles)
+		if err == nil && found {
+			resolved, err = s.authorize(req, name, resolved)
+		}
+		if err != nil {
+			s.Log.Printf("Unable to resolve config %q: %s", name, err)
+		} else if found && resolved.version != last {
+			js, _ := json.Marshal(ConfigEvent{Version: resolved.version, Document: resolved.document})
+			fmt.Fprintf(res, "id: %s\nevent: config\ndata: %s\n\n", resolved.version, js)
+			flusher.Flush()
+			last = resolved.version
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-updated:
+				waiting = false
+			case <-ticker.C:
+				fmt.Fprint(res, ": heartbeat\n\n")
+				flusher.Flush()
+			case <-req.Context().Done():
+				return
+			case <-s.closing:
+				return
+			}
+		}
+	}
+}
+
+// parseActionPath splits a config path ending in an action, such as
+// /watch, after a name and optional profile. The action is empty for
+// other paths.
+func parseActionPath(path string) (string, string) {
+	trimmed := strings.TrimSuffix(path, "/")
+	for _, action := range []string{"watch", "history", "pin"} {
+		if strings.HasSuffix(trimmed, "/"+action) && trimmed != "/"+action {
+			return strings.TrimSuffix(trimmed, "/"+action), action
+		}
+	}
+	return path, ""
+}
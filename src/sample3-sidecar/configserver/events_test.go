@@ -0,0 +1,120 @@
+package configserver_test
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sample3-sidecar/configserver"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watch streams", func() {
+	var (
+		backend *flakyBackend
+		server  *configserver.Server
+		stream  *httptest.Server
+	)
+
+	BeforeEach(func() {
+		backend = &flakyBackend{sources: []*configserver.Source{
+			{Name: "billing", Origin: "flaky:billing", Data: map[string]interface{}{"currency": "EUR"}},
+		}}
+		server = configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+		server.Heartbeat = 50 * time.Millisecond
+		Expect(server.Load()).To(Succeed())
+		stream = httptest.NewServer(server.Handler())
+	})
+
+	AfterEach(func() {
+		server.CloseStreams()
+		stream.Close()
+	})
+
+	// open starts a watch stream and returns a channel of its lines.
+	open := func(path, lastEventID string) (<-chan string, *http.Response) {
+		req, err := http.NewRequest("GET", stream.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+
+		lines := make(chan string, 100)
+		go func() {
+			defer close(lines)
+			scanner := bufio.NewScanner(res.Body)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+		return lines, res
+	}
+
+	version := func(currency string) string {
+		v, err := configserver.DocumentVersion(map[string]interface{}{"currency": currency})
+		Expect(err).NotTo(HaveOccurred())
+		return v
+	}
+
+	It("sends the current document and then every change", func() {
+		lines, res := open("/config/billing/watch", "")
+		defer res.Body.Close()
+		Expect(res.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		Eventually(lines).Should(Receive(Equal("id: " + version("EUR"))))
+		Eventually(lines).Should(Receive(Equal("event: config")))
+		Eventually(lines).Should(Receive(Equal(`data: {"version":"` + version("EUR") + `","document":{"currency":"EUR"}}`)))
+
+		backend.sources[0].Data = map[string]interface{}{"currency": "USD"}
+		Expect(server.Load()).To(Succeed())
+		Eventually(lines).Should(Receive(Equal("id: " + version("USD"))))
+	})
+
+	It("sends heartbeats while nothing changes", func() {
+		lines, res := open("/config/billing/watch", "")
+		defer res.Body.Close()
+
+		Eventually(lines).Should(Receive(Equal(": heartbeat")))
+	})
+
+	It("only sends an event on resume if the document changed", func() {
+		lines, res := open("/config/billing/watch", version("EUR"))
+		defer res.Body.Close()
+
+		Consistently(lines, 200*time.Millisecond).ShouldNot(Receive(HavePrefix("id:")))
+
+		backend.sources[0].Data = map[string]interface{}{"currency": "USD"}
+		Expect(server.Load()).To(Succeed())
+		Eventually(lines).Should(Receive(Equal("id: " + version("USD"))))
+	})
+
+	It("does not send an event when a reload leaves the document unchanged", func() {
+		lines, res := open("/config/billing/watch", "")
+		defer res.Body.Close()
+		Eventually(lines).Should(Receive(HavePrefix("data:")))
+
+		Expect(server.Load()).To(Succeed())
+		Consistently(lines, 200*time.Millisecond).ShouldNot(Receive(HavePrefix("id:")))
+	})
+
+	It("ends the streams when closed", func() {
+		lines, res := open("/config/billing/watch", "")
+		defer res.Body.Close()
+		Eventually(lines).Should(Receive(HavePrefix("data:")))
+
+		server.CloseStreams()
+		Eventually(lines).Should(BeClosed())
+	})
+
+	It("returns 404 for unknown names", func() {
+		res, err := http.Get(stream.URL + "/config/missing/watch")
+		Expect(err).NotTo(HaveOccurred())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,182 @@
+package configserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Explanation describes where each key of a merged document comes from.
+type Explanation struct {
+	Name     string   `json:"name"`
+	Profiles []string `json:"profiles"`
+	// Precedence lists the sources merged into the document, lowest
+	// precedence first.
+	Precedence []Layer                  `json:"precedence"`
+	Keys       map[string]KeyProvenance `json:"keys"`
+	// Removed holds the layer that removed each key, such as an ops file,
+	// when no later layer sets the key again.
+	Removed map[string]Layer `json:"removed,omitempty"`
+}
+
+// Layer is one source merged into a document. Name is the overlay the
+// source belongs to, such as billing-prod, and Origin the file or backend
+// it was loaded from.
+type Layer struct {
+	Name   string `json:"layer"`
+	Origin string `json:"origin"`
+}
+
+// KeyProvenance is the source that set the served value of a key, and the
+// lower precedence sources whose values for the key it overrides.
+type KeyProvenance struct {
+	Layer
+	Overrides []Layer `json:"overrides,omitempty"`
+}
+
+// Explain returns the provenance of every leaf key, flattened as in
+// Flatten, of the document for name and profiles. Values are not included,
+// so an explanation never reveals secrets. The second return value is false
+// when the document does not exist.
+func Explain(sources []*Source, name string, profiles []string) (*Explanation, bool) {
+	resolved, found := Resolve(sources, name, profiles)
+	if profiles == nil {
+		profiles = []string{}
+	}
+	explanation := &Explanation{
+		Name:       name,
+		Profiles:   profiles,
+		Precedence: []Layer{},
+		Keys:       map[string]KeyProvenance{},
+	}
+
+	// A key's value comes from the last source that has the key once
+	// flattened: a mapping is merged key by key, and any other value
+	// replaces the whole subtree along with the keys below it.
+	setBy := map[string][]Layer{}
+	removedBy := map[string]Layer{}
+	for _, source := range resolved {
+		layer := Layer{Name: source.Name, Origin: source.Origin}
+		explanation.Precedence = append(explanation.Precedence, layer)
+		for key := range Flatten(source.Data) {
+			setBy[key] = append(setBy[key], layer)
+		}
+		for _, key := range source.Removed {
+			removedBy[key] = layer
+		}
+	}
+	merged := Flatten(MergeSources(resolved))
+	for key := range merged {
+		layers := setBy[key]
+		provenance := KeyProvenance{Layer: layers[len(layers)-1]}
+		for i := len(layers) - 2; i >= 0; i-- {
+			provenance.Overrides = append(provenance.Overrides, layers[i])
+		}
+		explanation.Keys[key] = provenance
+	}
+	for key, layer := range removedBy {
+		if _, set := merged[key]; !set {
+			if explanation.Removed == nil {
+				explanation.Removed = map[string]Layer{}
+			}
+			explanation.Removed[key] = layer
+		}
+	}
+	return explanation, found
+}
+
+// WriteText writes the explanation as aligned tables, for people: the
+// layers highest precedence first, then each key sorted.
+func (e *Explanation) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LAYER\tORIGIN")
+	for i := len(e.Precedence) - 1; i >= 0; i-- {
+		fmt.Fprintf(tw, "%s\t%s\n", e.Precedence[i].Name, e.Precedence[i].Origin)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(e.Keys))
+	for key := range e.Keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "KEY\tLAYER\tORIGIN\tOVERRIDES")
+	for _, key := range keys {
+		provenance := e.Keys[key]
+		var overrides []string
+		for _, overridden := range provenance.Overrides {
+			overrides = append(overrides, overridden.Name)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key, provenance.Name, provenance.Origin, strings.Join(overrides, ", "))
+	}
+	if len(e.Removed) == 0 {
+		return tw.Flush()
+	}
+
+	removed := make([]string, 0, len(e.Removed))
+	for key := range e.Removed {
+		removed = append(removed, key)
+	}
+	sort.Strings(removed)
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "REMOVED KEY\tLAYER\tORIGIN")
+	for _, key := range removed {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, e.Removed[key].Name, e.Removed[key].Origin)
+	}
+	return tw.Flush()
+}
+
+// explain serves the explanation of a document, leaving out keys the
+// caller may not read. A pinned document is explained as it is served, as
+// a single layer holding the pinned version.
+func (s *Server) explain(res http.ResponseWriter, req *http.Request, name string, profiles []string) {
+	s.mu.RLock()
+	explanation, found := Explain(s.sources, name, profiles)
+	s.mu.RUnlock()
+	if pinned, ok := s.pinned(name, profiles); ok {
+		source, err := pinnedSource(name, pinned, s.viewVersion(req, name))
+		if err != nil {
+			s.Log.Printf("Unable to explain pinned config %q: %s", name, err)
+			http.Error(res, "unable to explain pinned document", http.StatusInternalServerError)
+			return
+		}
+		explanation, found = Explain([]*Source{source}, name, profiles)
+	}
+	if !found {
+		s.Log.Printf("Received an explain request for unknown config %q.", name)
+		http.Error(res, fmt.Sprintf("config %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	if identity := s.identity(req); s.Policy != nil && identity != nil {
+		for key := range explanation.Keys {
+			if !identity.Allows(name, key) {
+				delete(explanation.Keys, key)
+			}
+		}
+		for key := range explanation.Removed {
+			if !identity.Allows(name, key) {
+				delete(explanation.Removed, key)
+			}
+		}
+	}
+
+	js, err := json.Marshal(explanation)
+	if err != nil {
+		s.Log.Printf("Unable to marshal explanation of %q: %s", name, err)
+		http.Error(res, "unable to marshal explanation", http.StatusInternalServerError)
+		return
+	}
+	s.Log.Printf("Received an explain request for config %q.", name)
+	res.Header().Set("Content-Type", "application/json")
+	res.Write(append(js, '\n'))
+}
@@ -0,0 +1,153 @@
+package configserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Explain", func() {
+	sources := []*configserver.Source{
+		{Name: "application", Origin: "file:application.yml", Data: map[string]interface{}{
+			"log":   map[string]interface{}{"level": "info"},
+			"hosts": []interface{}{"a", "b"},
+		}},
+		{Name: "billing", Origin: "vault:secret/billing", Data: map[string]interface{}{
+			"log":            map[string]interface{}{"format": "json"},
+			"admin_password": "s3cret",
+		}},
+		{Name: "billing-prod", Origin: "file:billing-prod.yml", Data: map[string]interface{}{
+			"log":   map[string]interface{}{"level": "warn"},
+			"hosts": []interface{}{"c"},
+		}},
+	}
+
+	It("gives each key its layer and the layers it overrides", func() {
+		explanation, found := configserver.Explain(sources, "billing", []string{"prod"})
+		Expect(found).To(BeTrue())
+		Expect(explanation.Precedence).To(Equal([]configserver.Layer{
+			{Name: "application", Origin: "file:application.yml"},
+			{Name: "billing", Origin: "vault:secret/billing"},
+			{Name: "billing-prod", Origin: "file:billing-prod.yml"},
+		}))
+		Expect(explanation.Keys).To(Equal(map[string]configserver.KeyProvenance{
+			"log.level": {
+				Layer:     configserver.Layer{Name: "billing-prod", Origin: "file:billing-prod.yml"},
+				Overrides: []configserver.Layer{{Name: "application", Origin: "file:application.yml"}},
+			},
+			"log.format":     {Layer: configserver.Layer{Name: "billing", Origin: "vault:secret/billing"}},
+			"admin_password": {Layer: configserver.Layer{Name: "billing", Origin: "vault:secret/billing"}},
+			"hosts[0]": {
+				Layer:     configserver.Layer{Name: "billing-prod", Origin: "file:billing-prod.yml"},
+				Overrides: []configserver.Layer{{Name: "application", Origin: "file:application.yml"}},
+			},
+		}))
+	})
+
+	It("tells the keys set and removed by ops apart from the others", func() {
+		dir, err := ioutil.TempDir("", "configserver")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "prod.yml")
+		ops := "- type: replace\n  path: /billing/log/format\n  value: text\n- type: remove\n  path: /billing/admin_password\n"
+		Expect(ioutil.WriteFile(file, []byte(ops), 0644)).To(Succeed())
+
+		patched, err := (&configserver.OpsFiles{Patterns: []string{file}}).Apply(sources)
+		Expect(err).NotTo(HaveOccurred())
+		explanation, _ := configserver.Explain(patched, "billing", nil)
+
+		format := configserver.Layer{Name: "billing", Origin: "ops:" + file + ":/billing/log/format"}
+		password := configserver.Layer{Name: "billing", Origin: "ops:" + file + ":/billing/admin_password"}
+		Expect(explanation.Precedence).To(Equal([]configserver.Layer{
+			{Name: "application", Origin: "file:application.yml"},
+			{Name: "billing", Origin: "vault:secret/billing"},
+			format,
+			password,
+		}))
+		Expect(explanation.Keys["log.format"]).To(Equal(configserver.KeyProvenance{
+			Layer:     format,
+			Overrides: []configserver.Layer{{Name: "billing", Origin: "vault:secret/billing"}},
+		}))
+		Expect(explanation.Keys["log.level"].Layer).To(Equal(configserver.Layer{Name: "application", Origin: "file:application.yml"}))
+		Expect(explanation.Keys).NotTo(HaveKey("admin_password"))
+		Expect(explanation.Removed).To(Equal(map[string]configserver.Layer{"admin_password": password}))
+
+		out := &bytes.Buffer{}
+		Expect(explanation.WriteText(out)).To(Succeed())
+		Expect(out.String()).To(HaveSuffix("REMOVED KEY     LAYER    ORIGIN\nadmin_password  billing  " + password.Origin + "\n"))
+	})
+
+	It("reports unknown documents", func() {
+		_, found := configserver.Explain(sources, "shipping", nil)
+		Expect(found).To(BeFalse())
+	})
+
+	It("writes tables for people", func() {
+		explanation, _ := configserver.Explain(sources, "billing", nil)
+		out := &bytes.Buffer{}
+		Expect(explanation.WriteText(out)).To(Succeed())
+		Expect(out.String()).To(Equal(`LAYER        ORIGIN
+billing      vault:secret/billing
+application  file:application.yml
+
+KEY             LAYER        ORIGIN                OVERRIDES
+admin_password  billing      vault:secret/billing  
+hosts[0]        application  file:application.yml  
+hosts[1]        application  file:application.yml  
+log.format      billing      vault:secret/billing  
+log.level       application  file:application.yml  
+`))
+	})
+
+	Describe("serving", func() {
+		var server *configserver.Server
+
+		get := func(path, token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			res := httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, req)
+			return res
+		}
+
+		BeforeEach(func() {
+			server = newServer(sources...)
+		})
+
+		It("explains documents with ?explain=true", func() {
+			res := get("/config/billing/prod?explain=true", "")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			var explanation configserver.Explanation
+			Expect(json.Unmarshal(res.Body.Bytes(), &explanation)).To(Succeed())
+			Expect(explanation.Profiles).To(Equal([]string{"prod"}))
+			Expect(explanation.Keys["log.level"].Name).To(Equal("billing-prod"))
+			Expect(res.Body.String()).NotTo(ContainSubstring("s3cret"))
+
+			Expect(get("/config/shipping?explain=true", "").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("leaves out keys the caller may not read", func() {
+			policy, err := configserver.ParsePolicy([]byte(accessPolicy))
+			Expect(err).NotTo(HaveOccurred())
+			policy.Identities[1].Token = "web-token"
+			server.Policy = policy
+
+			var explanation configserver.Explanation
+			Expect(json.Unmarshal(get("/config/billing?explain=true", "web-token").Body.Bytes(), &explanation)).To(Succeed())
+			Expect(explanation.Keys).To(HaveKey("log.format"))
+			Expect(explanation.Keys).NotTo(HaveKey("admin_password"))
+		})
+	})
+})
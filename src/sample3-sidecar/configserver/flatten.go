@@ -0,0 +1,145 @@
+package configserver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Flatten converts a nested document into a flat map keyed by property
+// path, using the Spring conventions: nested keys are joined with "." and
+// list items are addressed as "key[0]". Empty mappings and lists are dropped.
+func Flatten(document map[string]interface{}) map[string]interface{} {
+	flat := map[string]interface{}{}
+	flattenInto(flat, "", document)
+	return flat
+}
+
+func flattenInto(flat map[string]interface{}, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenInto(flat, key, item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenInto(flat, fmt.Sprintf("%s[%d]", prefix, i), item)
+		}
+	default:
+		flat[prefix] = v
+	}
+}
+
+// SortedKeys returns the keys of a flattened document in sorted order.
+func SortedKeys(flat map[string]interface{}) []string {
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteProperties writes a document in Java .properties format, one
+// "key: value" line per flattened property, sorted by key.
+func WriteProperties(w io.Writer, document map[string]interface{}) error {
+	flat := Flatten(document)
+	for _, key := range SortedKeys(flat) {
+		_, err := fmt.Fprintf(w, "%s: %s\n", escapeProperty(key, true), escapeProperty(scalarString(flat[key]), false))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDotenv writes a document as KEY="value" lines, sorted by key. Keys
+// are the flattened property paths upper cased, with every run of other
+// characters replaced by "_", so "db.url" becomes DB_URL and "hosts[0]"
+// becomes HOSTS_0. Values are double quoted with \, ", $, ` and newlines
+// escaped, so the output can be read by dotenv libraries and sourced by sh.
+func WriteDotenv(w io.Writer, document map[string]interface{}) error {
+	flat := Flatten(document)
+	for _, key := range SortedKeys(flat) {
+		_, err := fmt.Fprintf(w, "%s=\"%s\"\n", dotenvKey(key), dotenvEscaper.Replace(scalarString(flat[key])))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var dotenvEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`", "\n", `\n`)
+
+func dotenvKey(key string) string {
+	var b strings.Builder
+	separate := false
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			if separate && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			separate = false
+			b.WriteRune(unicode.ToUpper(r))
+		default:
+			separate = true
+		}
+	}
+	name := b.String()
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// scalarString formats a flattened value. Numbers parsed from JSON are
+// float64, and are written without an exponent so that large integers
+// such as 1000000 keep their digits.
+func scalarString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return fmt.Sprint(value)
+}
+
+func escapeProperty(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteString(`\ `)
+			} else {
+				b.WriteRune(r)
+			}
+		case ':', '=', '#', '!':
+			if isKey {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package configserver_test
+
+import (
+	"bytes"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Flatten", func() {
+	It("joins nested keys with dots and indexes lists", func() {
+		Expect(configserver.Flatten(map[string]interface{}{
+			"db": map[string]interface{}{
+				"hosts": []interface{}{"a", map[string]interface{}{"name": "b"}},
+				"port":  5432,
+			},
+			"empty": map[string]interface{}{},
+		})).To(Equal(map[string]interface{}{
+			"db.hosts[0]":      "a",
+			"db.hosts[1].name": "b",
+			"db.port":          5432,
+		}))
+	})
+
+	It("writes escaped properties sorted by key", func() {
+		var out bytes.Buffer
+		Expect(configserver.WriteProperties(&out, map[string]interface{}{
+			"b":         "multi\nline",
+			"a key":     " leading space",
+			"with:char": nil,
+		})).To(Succeed())
+
+		Expect(out.String()).To(Equal("a\\ key: \\ leading space\nb: multi\\nline\nwith\\:char: \n"))
+	})
+
+	It("writes numbers parsed from JSON without an exponent", func() {
+		document := map[string]interface{}{
+			"pool":    1e6,
+			"account": 12345678901.0,
+			"limit":   float64(1 << 53),
+			"ratio":   0.25,
+			"port":    8080,
+		}
+
+		var properties bytes.Buffer
+		Expect(configserver.WriteProperties(&properties, document)).To(Succeed())
+		Expect(properties.String()).To(Equal("account: 12345678901\nlimit: 9007199254740992\npool: 1000000\nport: 8080\nratio: 0.25\n"))
+
+		var dotenv bytes.Buffer
+		Expect(configserver.WriteDotenv(&dotenv, document)).To(Succeed())
+		Expect(dotenv.String()).To(Equal("ACCOUNT=\"12345678901\"\nLIMIT=\"9007199254740992\"\nPOOL=\"1000000\"\nPORT=\"8080\"\nRATIO=\"0.25\"\n"))
+	})
+
+	It("writes quoted dotenv lines with upper cased keys", func() {
+		var out bytes.Buffer
+		Expect(configserver.WriteDotenv(&out, map[string]interface{}{
+			"db": map[string]interface{}{
+				"url":   "postgres://localhost",
+				"hosts": []interface{}{"a"},
+			},
+			"api-key": `a"b$c\d` + "`e\nf",
+			"2fa":     true,
+		})).To(Succeed())
+
+		Expect(out.String()).To(Equal(`_2FA="true"
+API_KEY="a\"b\$c\\d\` + "`" + `e\nf"
+DB_HOSTS_0="a"
+DB_URL="postgres://localhost"
+`))
+	})
+})
@@ -0,0 +1,213 @@
+package configserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Format is a representation documents can be served in.
+type Format struct {
+	Name string
+	// MediaTypes are matched against the Accept header. The first is sent
+	// as the Content-Type.
+	MediaTypes []string
+	// Extensions select the format when they end the request path.
+	Extensions []string
+	Write      func(w io.Writer, document map[string]interface{}) error
+}
+
+// ContentType returns the Content-Type documents are served with.
+func (f *Format) ContentType() string {
+	return f.MediaTypes[0]
+}
+
+// Formats are the representations documents can be served in. JSON is the
+// default.
+var Formats = []*Format{
+	{
+		Name:       "json",
+		MediaTypes: []string{"application/json"},
+		Extensions: []string{".json"},
+		Write: func(w io.Writer, document map[string]interface{}) error {
+			return json.NewEncoder(w).Encode(document)
+		},
+	},
+	{
+		Name:       "yaml",
+		MediaTypes: []string{"application/x-yaml", "application/yaml", "text/yaml", "text/x-yaml"},
+		Extensions: []string{".yml", ".yaml"},
+		Write:      WriteYAML,
+	},
+	{
+		Name:       "properties",
+		MediaTypes: []string{"text/x-java-properties"},
+		Extensions: []string{".properties"},
+		Write:      WriteProperties,
+	},
+	{
+		Name:       "dotenv",
+		MediaTypes: []string{"text/x-dotenv"},
+		Extensions: []string{".env"},
+		Write:      WriteDotenv,
+	},
+}
+
+// WriteYAML writes document as YAML, with numbers in positional notation.
+// Numbers read from JSON, Vault or CredHub are floats, which yaml.v2 writes
+// in exponent form from a million on, such as 1e+06, and YAML 1.1 parsers
+// read 1e+06 as a string. Whole numbers are written as integers. yaml.v2
+// cannot be told how to write the other floats, so placeholders are
+// marshalled in their place and replaced by their digits.
+func WriteYAML(w io.Writer, document map[string]interface{}) error {
+	exponent := false
+	out, err := yaml.Marshal(yamlNumbers(document, func(f float64) interface{} {
+		exponent = true
+		return f
+	}))
+	if err == nil && exponent {
+		// The placeholders must not occur anywhere else in the output.
+		prefix := "number-"
+		for bytes.Contains(out, []byte(prefix)) {
+			prefix = "_" + prefix
+		}
+		var replacements []string
+		out, err = yaml.Marshal(yamlNumbers(document, func(f float64) interface{} {
+			placeholder := fmt.Sprintf("%s%d-", prefix, len(replacements)/2)
+			replacements = append(replacements, placeholder, strconv.FormatFloat(f, 'f', -1, 64))
+			return placeholder
+		}))
+		out = []byte(strings.NewReplacer(replacements...).Replace(string(out)))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// yamlNumbers returns a copy of value with whole floats turned into
+// integers, and the floats yaml.v2 would write in exponent form into what
+// exponent returns.
+func yamlNumbers(value interface{}, exponent func(float64) interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = yamlNumbers(item, exponent)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = yamlNumbers(item, exponent)
+		}
+		return s
+	case float64:
+		switch {
+		case math.IsInf(v, 0) || math.IsNaN(v):
+			return v
+		case v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64:
+			return int64(v)
+		case strings.ContainsRune(strconv.FormatFloat(v, 'g', -1, 64), 'e'):
+			return exponent(v)
+		}
+	}
+	return value
+}
+
+// FormatForExtension returns the format selected by a file extension such
+// as ".yml", or nil if the extension is not a known format.
+func FormatForExtension(ext string) *Format {
+	for _, format := range Formats {
+		for _, candidate := range format.Extensions {
+			if ext == candidate {
+				return format
+			}
+		}
+	}
+	return nil
+}
+
+// NegotiateFormat returns the format preferred by an Accept header. An
+// empty header or a wildcard selects JSON. It returns false when none of
+// the acceptable media types is supported.
+func NegotiateFormat(accept string) (*Format, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return Formats[0], true
+	}
+
+	type candidate struct {
+		format *Format
+		q      float64
+	}
+	var candidates []candidate
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if format := formatForMediaType(mediaType); format != nil && q > 0 {
+			candidates = append(candidates, candidate{format, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	// The stable sort keeps header order between equally preferred types.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].format, true
+}
+
+func formatForMediaType(mediaType string) *Format {
+	if mediaType == "*/*" || mediaType == "application/*" {
+		return Formats[0]
+	}
+	for _, format := range Formats {
+		for _, candidate := range format.MediaTypes {
+			if mediaType == candidate {
+				return format
+			}
+		}
+	}
+	return nil
+}
+
+// splitFormatExtension removes a known format extension from the end of a
+// request path, returning the path and the format it selected.
+func splitFormatExtension(p string) (string, *Format) {
+	ext := path.Ext(p)
+	format := FormatForExtension(ext)
+	if format == nil {
+		return p, nil
+	}
+	return strings.TrimSuffix(p, ext), format
+}
+
+// supportedMediaTypes lists the media types of every format, for 406
+// responses.
+func supportedMediaTypes() string {
+	var types []string
+	for _, format := range Formats {
+		types = append(types, format.ContentType())
+	}
+	return strings.Join(types, ", ")
+}
@@ -0,0 +1,61 @@
+package configserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Formats", func() {
+	Describe("FormatForExtension", func() {
+		It("selects formats by extension", func() {
+			Expect(configserver.FormatForExtension(".yml").Name).To(Equal("yaml"))
+			Expect(configserver.FormatForExtension(".yaml").Name).To(Equal("yaml"))
+			Expect(configserver.FormatForExtension(".env").Name).To(Equal("dotenv"))
+			Expect(configserver.FormatForExtension(".properties").Name).To(Equal("properties"))
+			Expect(configserver.FormatForExtension(".txt")).To(BeNil())
+		})
+	})
+
+	Describe("WriteYAML", func() {
+		It("writes numbers parsed from JSON without an exponent", func() {
+			var document map[string]interface{}
+			Expect(json.Unmarshal([]byte(`{"db":{"pool":1000000,"account":12345678901,"ratio":1234567.5,"tiny":1e-7,"half":0.5},"name":"number-0-"}`), &document)).To(Succeed())
+
+			var out bytes.Buffer
+			Expect(configserver.WriteYAML(&out, document)).To(Succeed())
+			Expect(out.String()).To(Equal("db:\n  account: 12345678901\n  half: 0.5\n  pool: 1000000\n  ratio: 1234567.5\n  tiny: 0.0000001\nname: number-0-\n"))
+		})
+	})
+
+	Describe("NegotiateFormat", func() {
+		negotiate := func(accept string) string {
+			format, ok := configserver.NegotiateFormat(accept)
+			if !ok {
+				return "none"
+			}
+			return format.Name
+		}
+
+		It("defaults to JSON", func() {
+			Expect(negotiate("")).To(Equal("json"))
+			Expect(negotiate("*/*")).To(Equal("json"))
+			Expect(negotiate("text/html, */*;q=0.1")).To(Equal("json"))
+		})
+
+		It("picks the most preferred supported type", func() {
+			Expect(negotiate("application/x-yaml")).To(Equal("yaml"))
+			Expect(negotiate("text/x-java-properties;q=0.5, text/x-dotenv")).To(Equal("dotenv"))
+			Expect(negotiate("text/yaml, application/json")).To(Equal("yaml"))
+			Expect(negotiate("application/json;q=0, text/x-dotenv;q=0.2")).To(Equal("dotenv"))
+		})
+
+		It("fails when nothing acceptable is supported", func() {
+			Expect(negotiate("text/html")).To(Equal("none"))
+			Expect(negotiate("application/json;q=0")).To(Equal("none"))
+		})
+	})
+})
@@ -0,0 +1,273 @@
+package configserver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultPasswordLength and DefaultCertificateDuration apply when a
+// variable definition does not set the length or duration option.
+const (
+	DefaultPasswordLength      = 20
+	DefaultCertificateDuration = 365
+)
+
+const passwordAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// VariableDefinition declares a variable in the variables section of a
+// document. Variables with a Type are generated when no value is found.
+type VariableDefinition struct {
+	Name    string
+	Type    string
+	Options map[string]interface{}
+}
+
+// GenerateVariable generates a value for def. Certificates signed by a CA
+// call ca with the name of the CA variable, which must resolve to a value
+// with certificate and private_key keys.
+func GenerateVariable(def VariableDefinition, ca func(name string) (interface{}, bool, error)) (interface{}, error) {
+	switch def.Type {
+	case "password":
+		length, err := intOption(def.Options, "length", DefaultPasswordLength)
+		if err != nil {
+			return nil, err
+		}
+		return generatePassword(length)
+	case "rsa":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"private_key": encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+			"public_key":  encodePEM("PUBLIC KEY", public),
+		}, nil
+	case "ssh":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		public := sshPublicKey(&key.PublicKey)
+		return map[string]interface{}{
+			"private_key":            encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+			"public_key":             "ssh-rsa " + base64.StdEncoding.EncodeToString(public),
+			"public_key_fingerprint": fingerprint(public),
+		}, nil
+	case "certificate":
+		return generateCertificate(def, ca)
+	default:
+		return nil, fmt.Errorf("unknown variable type %q, expected password, certificate, rsa or ssh", def.Type)
+	}
+}
+
+func generatePassword(length int) (string, error) {
+	if length <= 0 {
+		return "", fmt.Errorf("invalid password length %d", length)
+	}
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = passwordAlphabet[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// generateCertificate generates a certificate signed by the CA named in the
+// ca option, or a self-signed CA when is_ca is set without a ca option.
+func generateCertificate(def VariableDefinition, lookup func(name string) (interface{}, bool, error)) (interface{}, error) {
+	isCA, _ := def.Options["is_ca"].(bool)
+	commonName, _ := def.Options["common_name"].(string)
+	caName, _ := def.Options["ca"].(string)
+	if caName == "" && !isCA {
+		return nil, fmt.Errorf("certificate %q needs a ca option, or is_ca to be self-signed", def.Name)
+	}
+	days, err := intOption(def.Options, "duration", DefaultCertificateDuration)
+	if err != nil {
+		return nil, err
+	}
+	alternativeNames, err := stringsOption(def.Options, "alternative_names")
+	if err != nil {
+		return nil, err
+	}
+	usages, err := stringsOption(def.Options, "extended_key_usage")
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(0, 0, days),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	for _, name := range alternativeNames {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	for _, usage := range usages {
+		switch usage {
+		case "server_auth":
+			template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		case "client_auth":
+			template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		default:
+			return nil, fmt.Errorf("unknown extended_key_usage %q, expected server_auth or client_auth", usage)
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	parent, signer, caPEM := template, key, ""
+	if caName != "" {
+		if parent, signer, caPEM, err = loadCA(caName, lookup); err != nil {
+			return nil, fmt.Errorf("certificate %q: %s", def.Name, err)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	certificate := encodePEM("CERTIFICATE", der)
+	if caPEM == "" {
+		caPEM = certificate
+	}
+	return map[string]interface{}{
+		"ca":          caPEM,
+		"certificate": certificate,
+		"private_key": encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+	}, nil
+}
+
+func loadCA(name string, lookup func(name string) (interface{}, bool, error)) (*x509.Certificate, *rsa.PrivateKey, string, error) {
+	value, found, err := lookup(name)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !found {
+		return nil, nil, "", fmt.Errorf("CA %q not found", name)
+	}
+	ca, _ := normalize(value).(map[string]interface{})
+	certificatePEM, _ := ca["certificate"].(string)
+	keyPEM, _ := ca["private_key"].(string)
+
+	block, _ := pem.Decode([]byte(certificatePEM))
+	if block == nil {
+		return nil, nil, "", fmt.Errorf("CA %q has no PEM certificate", name)
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("CA %q: %s", name, err)
+	}
+	block, _ = pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, nil, "", fmt.Errorf("CA %q has no PEM private_key", name)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("CA %q: %s", name, err)
+	}
+	return certificate, key, certificatePEM, nil
+}
+
+func encodePEM(kind string, der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}))
+}
+
+// sshPublicKey encodes key in the SSH wire format of RFC 4253.
+func sshPublicKey(key *rsa.PublicKey) []byte {
+	var buf bytes.Buffer
+	writeSSHString(&buf, []byte("ssh-rsa"))
+	writeSSHString(&buf, sshMPInt(big.NewInt(int64(key.E))))
+	writeSSHString(&buf, sshMPInt(key.N))
+	return buf.Bytes()
+}
+
+func writeSSHString(buf *bytes.Buffer, value []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	buf.Write(value)
+}
+
+// sshMPInt encodes a positive integer, with a leading zero byte when its
+// high bit is set so it is not read as negative.
+func sshMPInt(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// fingerprint returns the MD5 fingerprint of an SSH public key, as printed
+// by ssh-keygen -l -E md5.
+func fingerprint(public []byte) string {
+	sum := md5.Sum(public)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+func intOption(options map[string]interface{}, name string, fallback int) (int, error) {
+	switch value := options[name].(type) {
+	case nil:
+		return fallback, nil
+	case int:
+		return value, nil
+	case float64:
+		return int(value), nil
+	default:
+		return 0, fmt.Errorf("option %s must be a number, got %v", name, value)
+	}
+}
+
+func stringsOption(options map[string]interface{}, name string) ([]string, error) {
+	values, ok := options[name].([]interface{})
+	if options[name] != nil && !ok {
+		return nil, fmt.Errorf("option %s must be a list", name)
+	}
+	strs := make([]string, len(values))
+	for i, value := range values {
+		if strs[i], ok = value.(string); !ok {
+			return nil, fmt.Errorf("option %s must be a list of strings", name)
+		}
+	}
+	return strs, nil
+}
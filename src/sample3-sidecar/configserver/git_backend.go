@@ -0,0 +1,151 @@
+package configserver
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultGitRepository is where staging bundles the repository cloned from
+// $CONFIG_SERVER_GIT_URI, and the repository read by the git backend when
+// $CONFIG_SERVER_GIT_REPO is not set.
+const DefaultGitRepository = ".config-server/repo.git"
+
+// DefaultGitLabel selects the default branch of the repository.
+const DefaultGitLabel = "HEAD"
+
+// DefaultGitSearchPaths are the files read from the repository when
+// $CONFIG_SERVER_GIT_SEARCH_PATHS is not set.
+var DefaultGitSearchPaths = []string{"*.yml", "*.yaml", "*.json"}
+
+// GitBackend reads YAML and JSON files committed to a local git
+// repository, bare or not, with the git command. A label names the branch,
+// tag or commit files are read at. The repository is never fetched, so the
+// backend works offline.
+type GitBackend struct {
+	Repository string
+	// Label is loaded by Load. When empty, DefaultGitLabel is used.
+	Label string
+	// SearchPaths are path.Match patterns relative to the root of the
+	// repository, where * does not match /. When empty,
+	// DefaultGitSearchPaths are used.
+	SearchPaths []string
+}
+
+func (b *GitBackend) Name() string {
+	return "git"
+}
+
+func (b *GitBackend) Load() ([]*Source, error) {
+	label := b.Label
+	if label == "" {
+		label = DefaultGitLabel
+	}
+	return b.LoadLabel(label)
+}
+
+// Revision returns the hash of the commit label names. The label is never
+// read as an option, even when it starts with -.
+func (b *GitBackend) Revision(label string) (string, error) {
+	commit, err := b.git("rev-parse", "--verify", "--quiet", "--end-of-options", label+"^{commit}")
+	if err != nil {
+		// rev-parse --verify --quiet exits with status 1, and prints
+		// nothing, when the revision does not exist.
+		if gitErr, ok := err.(*gitError); ok && gitErr.status == 1 {
+			return "", &LabelNotFoundError{Label: label}
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(commit)), nil
+}
+
+// LoadLabel reads the files matching the search paths at label, in search
+// path order and sorted within each search path.
+func (b *GitBackend) LoadLabel(label string) ([]*Source, error) {
+	revision, err := b.Revision(label)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := b.git("ls-tree", "-r", "-z", "--name-only", revision)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range strings.Split(string(listing), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	searchPaths := b.SearchPaths
+	if len(searchPaths) == 0 {
+		searchPaths = DefaultGitSearchPaths
+	}
+	var sources []*Source
+	seen := map[string]bool{}
+	for _, pattern := range searchPaths {
+		for _, file := range files {
+			matched, err := path.Match(pattern, file)
+			if err != nil {
+				return nil, fmt.Errorf("invalid git search path %q: %s", pattern, err)
+			}
+			if !matched || seen[file] {
+				continue
+			}
+			seen[file] = true
+
+			contents, err := b.git("cat-file", "blob", revision+":"+file)
+			if err != nil {
+				return nil, err
+			}
+			data, err := parseDocument(file, contents)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse %s at %s: %s", file, label, err)
+			}
+			sources = append(sources, &Source{
+				Name:   strings.TrimSuffix(path.Base(file), path.Ext(file)),
+				Origin: fmt.Sprintf("git:%s/%s@%s", strings.TrimSuffix(b.Repository, "/"), file, revision[:12]),
+				Data:   data,
+			})
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no files match %s at %s", strings.Join(searchPaths, ", "), label)
+	}
+	return sources, nil
+}
+
+// git runs a git command in the repository and returns its output.
+func (b *GitBackend) git(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", b.Repository}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, &gitError{command: args[0], status: exitErr.ExitCode(), stderr: strings.TrimSpace(stderr.String())}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to run git: %s", err)
+	}
+	return out, nil
+}
+
+// gitError is a git command that exited with a failure status.
+type gitError struct {
+	command string
+	status  int
+	stderr  string
+}
+
+func (e *gitError) Error() string {
+	if e.stderr == "" {
+		return fmt.Sprintf("git %s exited with status %d", e.command, e.status)
+	}
+	return fmt.Sprintf("git %s: %s", e.command, e.stderr)
+}
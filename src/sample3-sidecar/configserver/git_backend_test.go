@@ -0,0 +1,162 @@
+package configserver_test
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GitBackend", func() {
+	var (
+		dir     string
+		work    string
+		backend *configserver.GitBackend
+	)
+
+	git := func(dir string, args ...string) string {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "init.defaultBranch=main"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	commit := func(files map[string]string) string {
+		for name, contents := range files {
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(work, name)), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(work, name), []byte(contents), 0644)).To(Succeed())
+		}
+		git(work, "add", ".")
+		git(work, "commit", "--quiet", "-m", "update")
+		return git(work, "rev-parse", "HEAD")
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "configserver")
+		Expect(err).NotTo(HaveOccurred())
+		work = filepath.Join(dir, "work")
+		Expect(os.Mkdir(work, 0755)).To(Succeed())
+		git(work, "init", "--quiet")
+
+		commit(map[string]string{
+			"application.yml":    "region: eu\n",
+			"billing.json":       `{"currency": "EUR"}`,
+			"README.md":          "not config\n",
+			"nested/billing.yml": "currency: GBP\n",
+		})
+		git(work, "tag", "v1")
+		git(work, "checkout", "--quiet", "-b", "feature")
+		commit(map[string]string{"billing.json": `{"currency": "USD"}`})
+		git(work, "checkout", "--quiet", "main")
+
+		// Read a bare clone, as staging bundles one.
+		git(dir, "clone", "--quiet", "--mirror", work, "repo.git")
+		backend = &configserver.GitBackend{Repository: filepath.Join(dir, "repo.git")}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("loads the files at the root of the default branch", func() {
+		head := git(work, "rev-parse", "main")
+		sources, err := backend.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sources).To(Equal([]*configserver.Source{
+			{Name: "application", Origin: "git:" + backend.Repository + "/application.yml@" + head[:12], Data: map[string]interface{}{"region": "eu"}},
+			{Name: "billing", Origin: "git:" + backend.Repository + "/billing.json@" + head[:12], Data: map[string]interface{}{"currency": "EUR"}},
+		}))
+	})
+
+	It("loads branches, tags and commits by label", func() {
+		for _, label := range []string{"feature", "v1", git(work, "rev-parse", "feature")} {
+			sources, err := backend.LoadLabel(label)
+			Expect(err).NotTo(HaveOccurred())
+			currency := "USD"
+			if label == "v1" {
+				currency = "EUR"
+			}
+			Expect(sources[1].Data).To(Equal(map[string]interface{}{"currency": currency}), label)
+		}
+
+		_, err := backend.LoadLabel("missing")
+		Expect(err).To(Equal(&configserver.LabelNotFoundError{Label: "missing"}))
+	})
+
+	It("reads the search paths", func() {
+		backend.SearchPaths = []string{"*.yml", "nested/*.yml"}
+		sources, err := backend.Load()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sources).To(HaveLen(2))
+		Expect(sources[1].Data).To(Equal(map[string]interface{}{"currency": "GBP"}))
+	})
+
+	It("reports missing repositories", func() {
+		backend.Repository = filepath.Join(dir, "missing.git")
+		_, err := backend.Load()
+		Expect(err).To(MatchError(HavePrefix("git rev-parse: ")))
+	})
+
+	It("serves documents by label", func() {
+		server := configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+		Expect(server.Load()).To(Succeed())
+		get := func(path string) *httptest.ResponseRecorder {
+			res := httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+			return res
+		}
+
+		Expect(get("/config/billing").Body.String()).To(MatchJSON(`{"region":"eu","currency":"EUR"}`))
+		Expect(get("/config/billing?label=feature").Body.String()).To(MatchJSON(`{"region":"eu","currency":"USD"}`))
+		Expect(get("/feature/billing-default.yml").Body.String()).To(Equal("currency: USD\nregion: eu\n"))
+		Expect(get("/billing/default/feature").Body.String()).To(ContainSubstring(`"source":{"currency":"USD"}`))
+
+		res := get("/billing/default/missing")
+		Expect(res.Code).To(Equal(http.StatusNotFound))
+		Expect(res.Body.String()).To(Equal("label \"missing\" not found\n"))
+	})
+
+	It("never reads labels as options", func() {
+		out := filepath.Join(dir, "out")
+		_, err := backend.LoadLabel("--output=" + out)
+		Expect(err).To(Equal(&configserver.LabelNotFoundError{Label: "--output=" + out}))
+		_, err = os.Stat(out)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("loads the sources of each commit once, whatever label names it", func() {
+		counting := &countingGitBackend{GitBackend: backend}
+		server := configserver.NewServer([]configserver.Backend{counting}, log.New(GinkgoWriter, "", 0))
+		Expect(server.Load()).To(Succeed())
+		loads := counting.loads
+
+		for _, label := range []string{"feature", "feature~0", "feature~0~0", git(work, "rev-parse", "feature")} {
+			res := httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, httptest.NewRequest("GET", "/config/billing?label="+label, nil))
+			Expect(res.Body.String()).To(MatchJSON(`{"region":"eu","currency":"USD"}`))
+		}
+		Expect(counting.loads).To(Equal(loads + 1))
+	})
+})
+
+// countingGitBackend counts the labels its GitBackend loads.
+type countingGitBackend struct {
+	*configserver.GitBackend
+	loads int
+}
+
+func (b *countingGitBackend) LoadLabel(label string) ([]*configserver.Source, error) {
+	b.loads++
+	return b.GitBackend.LoadLabel(label)
+}
@@ -0,0 +1,150 @@
+package configserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Version is the config-server release, set at build time with
+// -ldflags "-X sample3-sidecar/configserver.Version=<version>".
+var Version = "dev"
+
+// DefaultCheckInterval is how long backend checks are reused when
+// Server.CheckInterval is not set.
+const DefaultCheckInterval = 10 * time.Second
+
+// Checker is implemented by backends that can report whether their store is
+// currently reachable.
+type Checker interface {
+	Check() error
+}
+
+// Status is the body of the /status endpoint. Stale is set when the last
+// reload failed, so the documents served may be out of date, and
+// Violations lists the schema violations that failed it.
+type Status struct {
+	Version         string          `json:"version"`
+	Ready           bool            `json:"ready"`
+	Stale           bool            `json:"stale"`
+	LastReload      *time.Time      `json:"last_reload"`
+	LastReloadError *string         `json:"last_reload_error"`
+	LastSuccess     *time.Time      `json:"last_successful_reload"`
+	Violations      []Violation     `json:"schema_violations"`
+	Backends        []BackendStatus `json:"backends"`
+	Sources         []SourceStatus  `json:"sources"`
+}
+
+// BackendStatus reports whether a backend is reachable.
+type BackendStatus struct {
+	Name  string  `json:"name"`
+	Ready bool    `json:"ready"`
+	Error *string `json:"error"`
+}
+
+// SourceStatus describes a loaded source.
+type SourceStatus struct {
+	Name   string `json:"name"`
+	Origin string `json:"origin"`
+}
+
+// Status reports the state of the last reload and of every backend.
+// Backends implementing Checker are checked at most once per
+// CheckInterval, so that unauthenticated /readyz requests cannot flood
+// remote stores.
+func (s *Server) Status() Status {
+	s.mu.RLock()
+	status := Status{
+		Version:         Version,
+		LastReload:      timeOrNil(s.lastLoadAt),
+		LastReloadError: errorOrNil(s.loadErr),
+		LastSuccess:     timeOrNil(s.loadedAt),
+		Violations:      append([]Violation{}, s.violations...),
+		Sources:         make([]SourceStatus, len(s.sources)),
+	}
+	for i, source := range s.sources {
+		status.Sources[i] = SourceStatus{Name: source.Name, Origin: source.Origin}
+	}
+	status.Ready = !s.loadedAt.IsZero() && s.loadErr == nil
+	status.Stale = !s.loadedAt.IsZero() && s.loadErr != nil
+	s.mu.RUnlock()
+
+	status.Backends = s.checkBackends()
+	for _, backend := range status.Backends {
+		status.Ready = status.Ready && backend.Ready
+	}
+	return status
+}
+
+// checkBackends returns the state of every backend, checking them again
+// when the last checks are older than CheckInterval. Concurrent callers
+// wait for one round of checks.
+func (s *Server) checkBackends() []BackendStatus {
+	s.checkMu.Lock()
+	defer s.checkMu.Unlock()
+	interval := s.CheckInterval
+	if interval == 0 {
+		interval = DefaultCheckInterval
+	}
+	if s.checks != nil && time.Since(s.checkedAt) < interval {
+		return append([]BackendStatus{}, s.checks...)
+	}
+
+	checks := []BackendStatus{}
+	for _, backend := range s.backends {
+		backendStatus := BackendStatus{Name: backend.Name(), Ready: true}
+		if checker, ok := backend.(Checker); ok {
+			if err := checker.Check(); err != nil {
+				backendStatus.Ready = false
+				backendStatus.Error = errorOrNil(err)
+			}
+		}
+		checks = append(checks, backendStatus)
+	}
+	s.checks, s.checkedAt = checks, time.Now()
+	return append([]BackendStatus{}, checks...)
+}
+
+// healthz reports that the process is up.
+func (s *Server) healthz(res http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(res, "ok")
+}
+
+// readyz reports whether every backend loaded and, as of the last checks,
+// is still reachable.
+func (s *Server) readyz(res http.ResponseWriter, req *http.Request) {
+	status := s.Status()
+	if !status.Ready {
+		res.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(res, "not ready")
+		return
+	}
+	fmt.Fprintln(res, "ready")
+}
+
+// status serves the detailed Status as JSON.
+func (s *Server) status(res http.ResponseWriter, req *http.Request) {
+	js, err := json.Marshal(s.Status())
+	if err != nil {
+		http.Error(res, "unable to marshal status", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Write(js)
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func errorOrNil(err error) *string {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	return &message
+}
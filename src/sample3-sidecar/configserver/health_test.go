@@ -0,0 +1,147 @@
+package configserver_test
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sample3-sidecar/configserver"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// flakyBackend fails to load or check when its errors are set.
+type flakyBackend struct {
+	mu       sync.Mutex
+	sources  []*configserver.Source
+	loadErr  error
+	checkErr error
+	checked  int
+}
+
+func (b *flakyBackend) Name() string {
+	return "flaky"
+}
+
+// Remote makes the backend stand in for Vault or CredHub.
+func (b *flakyBackend) Remote() bool {
+	return true
+}
+
+func (b *flakyBackend) Load() ([]*configserver.Source, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sources, b.loadErr
+}
+
+// heal makes the backend load sources, while the server may be loading
+// it in the background.
+func (b *flakyBackend) heal(sources []*configserver.Source) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sources, b.loadErr = sources, nil
+}
+
+func (b *flakyBackend) Check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checked++
+	return b.checkErr
+}
+
+var _ = Describe("Health", func() {
+	var (
+		backend *flakyBackend
+		server  *configserver.Server
+	)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		return res
+	}
+
+	BeforeEach(func() {
+		backend = &flakyBackend{sources: []*configserver.Source{
+			{Name: "application", Origin: "flaky:application", Data: map[string]interface{}{}},
+		}}
+		server = configserver.NewServer([]configserver.Backend{staticBackend{}, backend}, log.New(GinkgoWriter, "", 0))
+		server.Token = "s3cret"
+	})
+
+	It("is healthy but not ready before the first load", func() {
+		Expect(get("/healthz").Code).To(Equal(http.StatusOK))
+		Expect(get("/readyz").Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("is ready once every backend loaded", func() {
+		Expect(server.Load()).To(Succeed())
+		Expect(get("/readyz").Code).To(Equal(http.StatusOK))
+	})
+
+	It("is not ready when a reload fails, while still serving the last documents", func() {
+		Expect(server.Load()).To(Succeed())
+		backend.loadErr = errors.New("connection refused")
+		Expect(server.Load()).NotTo(Succeed())
+
+		Expect(get("/readyz").Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(server.Status().LastReloadError).NotTo(BeNil())
+		Expect(*server.Status().LastReloadError).To(Equal("flaky backend: connection refused"))
+		Expect(server.Status().LastSuccess).NotTo(BeNil())
+
+		backend.loadErr = nil
+		Expect(server.Load()).To(Succeed())
+		Expect(get("/readyz").Code).To(Equal(http.StatusOK))
+	})
+
+	It("is not ready when a backend becomes unreachable", func() {
+		Expect(server.Load()).To(Succeed())
+		backend.checkErr = errors.New("sealed")
+
+		Expect(get("/readyz").Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("reuses backend checks for the check interval", func() {
+		Expect(server.Load()).To(Succeed())
+		server.CheckInterval = 50 * time.Millisecond
+		for i := 0; i < 10; i++ {
+			Expect(get("/readyz").Code).To(Equal(http.StatusOK))
+		}
+		Expect(backend.checked).To(Equal(1))
+
+		backend.checkErr = errors.New("sealed")
+		Expect(get("/readyz").Code).To(Equal(http.StatusOK))
+		Eventually(func() int { return get("/readyz").Code }).Should(Equal(http.StatusServiceUnavailable))
+		Expect(backend.checked).To(BeNumerically("<", 10))
+	})
+
+	It("reports sources, backends and version on /status to authenticated callers", func() {
+		Expect(server.Load()).To(Succeed())
+		backend.checkErr = errors.New("sealed")
+
+		Expect(get("/status").Code).To(Equal(http.StatusUnauthorized))
+
+		req := httptest.NewRequest("GET", "/status", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, req)
+		Expect(res.Code).To(Equal(http.StatusOK))
+
+		var status map[string]interface{}
+		Expect(json.Unmarshal(res.Body.Bytes(), &status)).To(Succeed())
+		Expect(status).To(HaveKeyWithValue("version", configserver.Version))
+		Expect(status).To(HaveKeyWithValue("ready", false))
+		Expect(status).To(HaveKey("last_reload"))
+		Expect(status["sources"]).To(Equal([]interface{}{
+			map[string]interface{}{"name": "application", "origin": "flaky:application"},
+		}))
+		Expect(status["backends"]).To(Equal([]interface{}{
+			map[string]interface{}{"name": "static", "ready": true, "error": nil},
+			map[string]interface{}{"name": "flaky", "ready": false, "error": "sealed"},
+		}))
+	})
+})
@@ -0,0 +1,452 @@
+package configserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultHistorySize is how many versions of each document are kept when
+// $CONFIG_SERVER_HISTORY_SIZE is not set.
+const DefaultHistorySize = 10
+
+// MaxHistoryDocuments bounds how many documents have their history kept.
+// Every profile list a client requests is a document of its own, so the
+// documents served after the limit is reached are not recorded.
+const MaxHistoryDocuments = 1000
+
+// DocumentHistory is the body of /config/<name>[/<profile>]/history.
+type DocumentHistory struct {
+	Name     string   `json:"name"`
+	Profiles []string `json:"profiles"`
+	// Pinned is the version the document is frozen to, if any.
+	Pinned   *string        `json:"pinned"`
+	Versions []HistoryEntry `json:"versions"`
+}
+
+// HistoryEntry is a version of a document, identified by the hash also
+// used as its ETag. Current is set on the version the loaded sources
+// resolve to, which is not served while another version is pinned.
+type HistoryEntry struct {
+	Version  string    `json:"version"`
+	LoadedAt time.Time `json:"loaded_at"`
+	Current  bool      `json:"current"`
+}
+
+type documentVersion struct {
+	resolved *resolvedDocument
+	loadedAt time.Time
+}
+
+// versionView returns the version of a document as a caller sees it.
+type versionView func(resolved *resolvedDocument) (string, error)
+
+func storedVersion(resolved *resolvedDocument) (string, error) {
+	return resolved.version, nil
+}
+
+// viewVersion returns the view of versions served to req. Under a policy,
+// callers that are not admins see the hashes of the keys they may read,
+// the versions they get as ETags, so that versions reveal nothing of the
+// keys denied to them. Admins see the stored versions they pin.
+func (s *Server) viewVersion(req *http.Request, name string) versionView {
+	identity := s.identity(req)
+	if s.Policy == nil || identity == nil || identity.Admin {
+		return storedVersion
+	}
+	return func(resolved *resolvedDocument) (string, error) {
+		filtered, _ := s.Policy.Filter(identity, name, resolved.document)
+		return DocumentVersion(filtered)
+	}
+}
+
+func documentKey(name string, profiles []string) string {
+	return name + "/" + strings.Join(profiles, ",")
+}
+
+func parseDocumentKey(key string) (string, []string) {
+	i := strings.Index(key, "/")
+	return key[:i], splitList(key[i+1:])
+}
+
+// recordVersion appends a version to the history of a document, unless it
+// is the latest one already or MaxHistoryDocuments other documents are
+// recorded. s.mu must be held.
+func (s *Server) recordVersion(key string, resolved *resolvedDocument, loadedAt time.Time) {
+	versions, recorded := s.versions[key]
+	if !recorded && len(s.versions) >= MaxHistoryDocuments {
+		return
+	}
+	if len(versions) > 0 && versions[len(versions)-1].resolved.version == resolved.version {
+		return
+	}
+	versions = append(versions, &documentVersion{resolved: resolved, loadedAt: loadedAt})
+
+	size := s.HistorySize
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	if len(versions) > size {
+		versions = versions[len(versions)-size:]
+	}
+	s.versions[key] = versions
+	s.historyChanged = true
+}
+
+// recordHistory resolves every document served before, so that a Load
+// changing it is recorded even if the document is not requested again.
+func (s *Server) recordHistory() {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.versions))
+	for key := range s.versions {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+
+	for _, key := range keys {
+		name, profiles := parseDocumentKey(key)
+		if _, _, _, err := s.currentDocument(key, name, profiles); err != nil {
+			s.Log.Printf("Unable to record the new version of config %q: %s", name, err)
+		}
+	}
+	s.saveHistory()
+}
+
+// History returns the versions kept of the document for name and
+// profiles, newest first. It records nothing, so a document that was never
+// served has no history.
+func (s *Server) History(name string, profiles []string) (DocumentHistory, bool, error) {
+	return s.documentHistory(name, profiles, storedVersion)
+}
+
+// documentHistory returns the history of a document with its versions as
+// seen through view. Consecutive versions that look the same are listed
+// once, from the first time they were loaded.
+func (s *Server) documentHistory(name string, profiles []string, view versionView) (DocumentHistory, bool, error) {
+	key := documentKey(name, profiles)
+	if profiles == nil {
+		profiles = []string{}
+	}
+	history := DocumentHistory{Name: name, Profiles: profiles, Versions: []HistoryEntry{}}
+	current, found, _, _, err := s.computeDocument(name, profiles)
+	var currentVersion string
+	if err == nil && found {
+		if currentVersion, err = view(current); err != nil {
+			return history, found, err
+		}
+	}
+
+	s.mu.RLock()
+	pinned, isPinned := s.pins[key]
+	versions := s.versions[key]
+	s.mu.RUnlock()
+
+	if isPinned {
+		version, err := view(pinned.resolved)
+		if err != nil {
+			return history, found, err
+		}
+		history.Pinned = &version
+	}
+	var entries []HistoryEntry
+	for _, v := range versions {
+		version, err := view(v.resolved)
+		if err != nil {
+			return history, found, err
+		}
+		if len(entries) > 0 && entries[len(entries)-1].Version == version {
+			continue
+		}
+		entries = append(entries, HistoryEntry{
+			Version:  version,
+			LoadedAt: v.loadedAt,
+			Current:  currentVersion != "" && version == currentVersion,
+		})
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		history.Versions = append(history.Versions, entries[i])
+	}
+	return history, found || len(versions) > 0, nil
+}
+
+// findVersion returns a version kept of the document for name and
+// profiles, matching version against the versions seen through view.
+func (s *Server) findVersion(name string, profiles []string, version string, view versionView) (*documentVersion, bool, error) {
+	key := documentKey(name, profiles)
+	s.mu.RLock()
+	candidates := append([]*documentVersion(nil), s.versions[key]...)
+	if pinned, ok := s.pins[key]; ok {
+		candidates = append(candidates, pinned)
+	}
+	s.mu.RUnlock()
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		v, err := view(candidates[i].resolved)
+		if err != nil {
+			return nil, false, err
+		}
+		if v == version {
+			return candidates[i], true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// pinned returns the version the document for name and profiles is pinned
+// to. Spring clients request the default profile when no profile is
+// active, so a pin of the document without profiles also applies to its
+// default profile, unless that is pinned itself.
+func (s *Server) pinned(name string, profiles []string) (*documentVersion, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if pinned, ok := s.pins[documentKey(name, profiles)]; ok {
+		return pinned, true
+	}
+	if len(profiles) == 1 && profiles[0] == SpringDefaultProfile {
+		pinned, ok := s.pins[documentKey(name, nil)]
+		return pinned, ok
+	}
+	return nil, false
+}
+
+// pinnedSource returns the pinned version of a document as a single
+// source, named after the version as view shows it.
+func pinnedSource(name string, pinned *documentVersion, view versionView) (*Source, error) {
+	version, err := view(pinned.resolved)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{Name: name, Origin: "pinned:" + version, Data: pinned.resolved.document}, nil
+}
+
+// Pin freezes the document for name and profiles to a version in its
+// history until Unpin is called. The version stays pinned when it drops
+// out of the history.
+func (s *Server) Pin(name string, profiles []string, version string) error {
+	pinned, ok, _ := s.findVersion(name, profiles, version, storedVersion)
+	if !ok {
+		return fmt.Errorf("version %q of config %q not found", version, name)
+	}
+	s.mu.Lock()
+	s.pins[documentKey(name, profiles)] = pinned
+	s.historyChanged = true
+	s.notify()
+	s.mu.Unlock()
+	s.saveHistory()
+	return nil
+}
+
+// Unpin serves the current version of the document for name and profiles
+// again. It reports whether the document was pinned.
+func (s *Server) Unpin(name string, profiles []string) bool {
+	key := documentKey(name, profiles)
+	s.mu.Lock()
+	if _, ok := s.pins[key]; !ok {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.pins, key)
+	s.historyChanged = true
+	s.notify()
+	s.mu.Unlock()
+	s.saveHistory()
+	return true
+}
+
+// history serves the versions kept of a document. They reveal no values,
+// so every caller may read them, as hashes of the keys it may read.
+func (s *Server) history(res http.ResponseWriter, req *http.Request, name string, profiles []string) {
+	history, found, err := s.documentHistory(name, profiles, s.viewVersion(req, name))
+	if !found {
+		s.Log.Printf("Received a history request for unknown config %q.", name)
+		http.Error(res, fmt.Sprintf("config %q not found", name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.Log.Printf("Unable to list the versions of config %q: %s", name, err)
+		http.Error(res, "unable to list config versions", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(res, history)
+}
+
+// pin serves POST /config/<name>[/<profile>]/pin, which pins the version
+// given by the version parameter, or else the current one, and DELETE,
+// which unpins the document. Only admins may pin documents.
+func (s *Server) pin(res http.ResponseWriter, req *http.Request, name string, profiles []string) {
+	if req.Method != http.MethodPost && req.Method != http.MethodDelete {
+		res.Header().Set("Allow", http.MethodPost+", "+http.MethodDelete)
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.admin(req) {
+		s.Log.Printf("Rejected a request to pin config %q from a caller that is not an admin.", name)
+		http.Error(res, "pinning config requires an admin identity", http.StatusForbidden)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		if s.Unpin(name, profiles) {
+			s.Log.Printf("Unpinned config %q.", name)
+		}
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	version := req.FormValue("version")
+	if version == "" {
+		current, found, _, err := s.currentDocument(documentKey(name, profiles), name, profiles)
+		if !found {
+			http.Error(res, fmt.Sprintf("config %q not found", name), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.Log.Printf("Unable to resolve config %q: %s", name, err)
+			http.Error(res, "unable to decrypt config", http.StatusInternalServerError)
+			return
+		}
+		version = current.version
+	}
+	if err := s.Pin(name, profiles, version); err != nil {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.Log.Printf("Pinned config %q to version %s.", name, version)
+
+	history, _, err := s.History(name, profiles)
+	if err != nil {
+		s.Log.Printf("Unable to list the versions of config %q: %s", name, err)
+		http.Error(res, "unable to list config versions", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(res, history)
+}
+
+func (s *Server) writeJSON(res http.ResponseWriter, value interface{}) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		s.Log.Printf("Unable to marshal response: %s", err)
+		http.Error(res, "unable to marshal response", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Write(append(js, '\n'))
+}
+
+// historyFile is the plaintext of the file the history and pins are saved
+// to, so that a rollback outlives a restart.
+type historyFile struct {
+	Documents []*historyDocument `json:"documents"`
+}
+
+type historyDocument struct {
+	Key      string          `json:"key"`
+	Versions []*savedVersion `json:"versions"`
+	Pinned   *savedVersion   `json:"pinned,omitempty"`
+}
+
+type savedVersion struct {
+	LoadedAt time.Time       `json:"loaded_at"`
+	Document json.RawMessage `json:"document"`
+}
+
+func saveVersion(v *documentVersion) *savedVersion {
+	return &savedVersion{LoadedAt: v.loadedAt, Document: v.resolved.json}
+}
+
+func (v *savedVersion) restore() (*documentVersion, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(v.Document, &document); err != nil {
+		return nil, err
+	}
+	if document == nil {
+		document = map[string]interface{}{}
+	}
+	resolved := &resolvedDocument{document: document, json: v.Document, version: jsonVersion(v.Document)}
+	return &documentVersion{resolved: resolved, loadedAt: v.LoadedAt}, nil
+}
+
+// saveHistory writes the history and pins next to the cache if they
+// changed since they were last saved. Without a cache they are only kept in
+// memory.
+func (s *Server) saveHistory() {
+	if s.Cache == nil {
+		return
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if !s.historyChanged {
+		s.mu.Unlock()
+		return
+	}
+	s.historyChanged = false
+	keys := make([]string, 0, len(s.versions))
+	for key := range s.versions {
+		keys = append(keys, key)
+	}
+	for key := range s.pins {
+		if _, ok := s.versions[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var file historyFile
+	for _, key := range keys {
+		document := &historyDocument{Key: key}
+		for _, v := range s.versions[key] {
+			document.Versions = append(document.Versions, saveVersion(v))
+		}
+		if pinned, ok := s.pins[key]; ok {
+			document.Pinned = saveVersion(pinned)
+		}
+		file.Documents = append(file.Documents, document)
+	}
+	s.mu.Unlock()
+
+	if err := s.Cache.write(s.Cache.historyPath(), file); err != nil {
+		s.Log.Printf("Unable to save the config history %s: %s", s.Cache.historyPath(), err)
+	}
+}
+
+// restoreHistory reads the history and pins saved next to the cache.
+func (s *Server) restoreHistory() {
+	if s.Cache == nil {
+		return
+	}
+	path := s.Cache.historyPath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+	var file historyFile
+	if err := s.Cache.read(path, &file); err != nil {
+		s.Log.Printf("Unable to restore the config history: %s", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, document := range file.Documents {
+		for _, saved := range document.Versions {
+			v, err := saved.restore()
+			if err != nil {
+				s.Log.Printf("Unable to restore a version of config %q: %s", document.Key, err)
+				continue
+			}
+			s.versions[document.Key] = append(s.versions[document.Key], v)
+		}
+		if document.Pinned != nil {
+			pinned, err := document.Pinned.restore()
+			if err != nil {
+				s.Log.Printf("Unable to restore the pinned version of config %q: %s", document.Key, err)
+				continue
+			}
+			s.pins[document.Key] = pinned
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package configserver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+	var (
+		backend *flakyBackend
+		server  *configserver.Server
+	)
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, req)
+		return res
+	}
+
+	// load serves the billing document with currency, and returns its
+	// version.
+	load := func(currency string) string {
+		backend.sources = []*configserver.Source{
+			{Name: "billing", Origin: "flaky:billing", Data: map[string]interface{}{"currency": currency}},
+		}
+		Expect(server.Load()).To(Succeed())
+		version, err := configserver.DocumentVersion(map[string]interface{}{"currency": currency})
+		Expect(err).NotTo(HaveOccurred())
+		return version
+	}
+
+	history := func(path string) configserver.DocumentHistory {
+		res := request("GET", path, "")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		var history configserver.DocumentHistory
+		Expect(json.Unmarshal(res.Body.Bytes(), &history)).To(Succeed())
+		return history
+	}
+
+	BeforeEach(func() {
+		backend = &flakyBackend{}
+		server = configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+		server.HistorySize = 2
+	})
+
+	It("lists the versions of a document, newest first", func() {
+		eur := load("EUR")
+		request("GET", "/config/billing", "")
+		usd := load("USD")
+		load("USD")
+
+		h := history("/config/billing/history")
+		Expect(h.Name).To(Equal("billing"))
+		Expect(h.Pinned).To(BeNil())
+		Expect(h.Versions).To(HaveLen(2))
+		Expect(h.Versions[0].Version).To(Equal(usd))
+		Expect(h.Versions[0].Current).To(BeTrue())
+		Expect(h.Versions[1].Version).To(Equal(eur))
+		Expect(h.Versions[1].Current).To(BeFalse())
+		Expect(h.Versions[0].LoadedAt.Before(h.Versions[1].LoadedAt)).To(BeFalse())
+
+		load("GBP")
+		Expect(history("/config/billing/history").Versions[1].Version).To(Equal(usd))
+		Expect(request("GET", "/config/missing/history", "").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("serves earlier versions", func() {
+		eur := load("EUR")
+		request("GET", "/config/billing", "")
+		load("USD")
+
+		res := request("GET", "/config/billing.yml?version="+eur, "")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(Equal("currency: EUR\n"))
+		Expect(res.Header().Get("ETag")).To(Equal(`"` + eur + `-yaml"`))
+
+		Expect(request("GET", "/config/billing?version=0123456789abcdef", "").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("pins documents to a version until unpinned", func() {
+		eur := load("EUR")
+		request("GET", "/config/billing", "")
+		load("USD")
+
+		res := request("POST", "/config/billing/pin?version="+eur, "")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "/config/billing", "").Body.String()).To(MatchJSON(`{"currency":"EUR"}`))
+
+		load("GBP")
+		Expect(request("GET", "/config/billing", "").Body.String()).To(MatchJSON(`{"currency":"EUR"}`))
+		h := history("/config/billing/history")
+		Expect(*h.Pinned).To(Equal(eur))
+		Expect(h.Versions[0].Current).To(BeTrue())
+		Expect(h.Versions[0].Version).NotTo(Equal(eur))
+
+		Expect(request("DELETE", "/config/billing/pin", "").Code).To(Equal(http.StatusNoContent))
+		Expect(request("GET", "/config/billing", "").Body.String()).To(MatchJSON(`{"currency":"GBP"}`))
+	})
+
+	It("pins the current version by default", func() {
+		load("EUR")
+		Expect(request("POST", "/config/billing/pin", "").Code).To(Equal(http.StatusOK))
+		load("USD")
+		Expect(request("GET", "/config/billing", "").Body.String()).To(MatchJSON(`{"currency":"EUR"}`))
+
+		Expect(request("POST", "/config/billing/pin?version=0123456789abcdef", "").Code).To(Equal(http.StatusNotFound))
+		Expect(request("GET", "/config/billing/pin", "").Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	It("explains pinned documents as they are served", func() {
+		eur := load("EUR")
+		Expect(request("POST", "/config/billing/pin", "").Code).To(Equal(http.StatusOK))
+		load("USD")
+
+		pinned := configserver.Layer{Name: "billing", Origin: "pinned:" + eur}
+		for _, path := range []string{"/config/billing?explain=true", "/config/billing/default?explain=true"} {
+			var explanation configserver.Explanation
+			Expect(json.Unmarshal(request("GET", path, "").Body.Bytes(), &explanation)).To(Succeed())
+			Expect(explanation.Precedence).To(Equal([]configserver.Layer{pinned}), path)
+			Expect(explanation.Keys).To(Equal(map[string]configserver.KeyProvenance{"currency": {Layer: pinned}}), path)
+		}
+	})
+
+	It("only lets admins pin documents", func() {
+		load("EUR")
+		policy, err := configserver.ParsePolicy([]byte(accessPolicy + "  ops:\n    allow: ['*']\n    admin: true\n"))
+		Expect(err).NotTo(HaveOccurred())
+		for _, identity := range policy.Identities {
+			identity.Token = identity.Name + "-token"
+		}
+		server.Policy = policy
+
+		Expect(request("POST", "/config/billing/pin", "web-token").Code).To(Equal(http.StatusForbidden))
+		Expect(request("POST", "/config/billing/pin", "ops-token").Code).To(Equal(http.StatusOK))
+	})
+
+	It("records nothing when the history is read", func() {
+		load("EUR")
+		Expect(history("/config/billing/history").Versions).To(BeEmpty())
+
+		usd := load("USD")
+		request("GET", "/config/billing", "")
+		h := history("/config/billing/history")
+		Expect(h.Versions).To(HaveLen(1))
+		Expect(h.Versions[0].Version).To(Equal(usd))
+	})
+
+	It("stops recording new documents at a limit", func() {
+		load("EUR")
+		for i := 0; i < configserver.MaxHistoryDocuments; i++ {
+			request("GET", fmt.Sprintf("/config/billing/p%d", i), "")
+		}
+		request("GET", "/config/billing", "")
+		Expect(history("/config/billing/p0/history").Versions).To(HaveLen(1))
+		Expect(history("/config/billing/history").Versions).To(BeEmpty())
+
+		load("USD")
+		Expect(history("/config/billing/p0/history").Versions).To(HaveLen(2))
+	})
+
+	It("lists the versions of the keys a caller may read", func() {
+		policy, err := configserver.ParsePolicy([]byte(accessPolicy + "  ops:\n    allow: ['*']\n    admin: true\n"))
+		Expect(err).NotTo(HaveOccurred())
+		for _, identity := range policy.Identities {
+			identity.Token = identity.Name + "-token"
+		}
+		server.Policy = policy
+		server.HistorySize = 5
+
+		serve := func(password string) {
+			backend.sources = []*configserver.Source{
+				{Name: "billing", Origin: "flaky:billing", Data: map[string]interface{}{"currency": "EUR", "admin_password": password}},
+			}
+			Expect(server.Load()).To(Succeed())
+			request("GET", "/config/billing", "ops-token")
+		}
+		serve("first")
+		serve("second")
+
+		var h configserver.DocumentHistory
+		res := request("GET", "/config/billing/history", "ops-token")
+		Expect(json.Unmarshal(res.Body.Bytes(), &h)).To(Succeed())
+		Expect(h.Versions).To(HaveLen(2))
+
+		etag := request("GET", "/config/billing", "web-token").Header().Get("ETag")
+		res = request("GET", "/config/billing/history", "web-token")
+		Expect(json.Unmarshal(res.Body.Bytes(), &h)).To(Succeed())
+		Expect(h.Versions).To(HaveLen(1))
+		Expect(`"` + h.Versions[0].Version + `"`).To(Equal(etag))
+		Expect(h.Versions[0].Current).To(BeTrue())
+
+		res = request("GET", "/config/billing?version="+h.Versions[0].Version, "web-token")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(MatchJSON(`{"currency":"EUR"}`))
+	})
+
+	Context("with a cache", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "configserver")
+			Expect(err).NotTo(HaveOccurred())
+			cipher, err := configserver.NewCipher(testKey)
+			Expect(err).NotTo(HaveOccurred())
+			server.Cache = &configserver.Cache{Path: filepath.Join(dir, "config.cache"), Cipher: cipher}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("keeps the history and pins across restarts", func() {
+			eur := load("EUR")
+			request("GET", "/config/billing", "")
+			Expect(request("POST", "/config/billing/pin", "").Code).To(Equal(http.StatusOK))
+
+			cache := server.Cache
+			server = configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+			server.HistorySize = 2
+			server.Cache = cache
+			usd := load("USD")
+
+			Expect(request("GET", "/config/billing", "").Body.String()).To(MatchJSON(`{"currency":"EUR"}`))
+			h := history("/config/billing/history")
+			Expect(*h.Pinned).To(Equal(eur))
+			Expect(h.Versions).To(HaveLen(2))
+			Expect(h.Versions[0].Version).To(Equal(usd))
+			Expect(h.Versions[1].Version).To(Equal(eur))
+
+			Expect(request("GET", "/config/billing.yml?version="+eur, "").Body.String()).To(Equal("currency: EUR\n"))
+		})
+	})
+})
@@ -0,0 +1,194 @@
+package configserver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry/bosh-cli/director/template"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultVarsEnvPrefix is the prefix of environment variables that set
+// ((variables)) when $CONFIG_SERVER_VARS_ENV is not set.
+const DefaultVarsEnvPrefix = "CONFIG_VAR"
+
+// VariableStore is implemented by backends that can resolve ((variables)).
+type VariableStore interface {
+	Variable(name string) (interface{}, bool, error)
+}
+
+// Interpolator replaces BOSH style ((name)) and ((name.key)) placeholders
+// in loaded documents. Variables are looked up, first match wins, in:
+//
+//  1. environment variables named <EnvPrefix>_<name>, parsed as YAML
+//  2. the vars files, later files first
+//  3. the Stores, later stores first
+//  4. values generated for variables declared with a type in a variables
+//     section, persisted to VarsStore
+type Interpolator struct {
+	EnvPrefix string
+	// Environ returns the environment, defaulting to os.Environ.
+	Environ   func() []string
+	VarsFiles []string
+	Stores    []VariableStore
+	// VarsStore persists generated variables. When nil, declared variables
+	// cannot be generated and must be provided by another source.
+	VarsStore VariableWriter
+	// Strict fails the load when a placeholder cannot be resolved, instead
+	// of serving it as a literal.
+	Strict bool
+}
+
+// NewInterpolator returns an interpolator using the vars files and
+// environment prefix in settings, and every backend able to resolve
+// variables.
+func NewInterpolator(settings Settings, backends []Backend) *Interpolator {
+	interpolator := &Interpolator{
+		EnvPrefix: settings.VarsEnvPrefix,
+		VarsFiles: settings.VarsFiles,
+		Strict:    settings.StrictVars,
+	}
+	for _, backend := range backends {
+		if store, ok := backend.(VariableStore); ok {
+			interpolator.Stores = append(interpolator.Stores, store)
+		}
+	}
+	return interpolator
+}
+
+// Interpolate returns copies of sources with their placeholders replaced
+// and their variables sections removed. Variables declared in any document
+// can be used by every document. Vars files are read on every call, so a
+// reload picks up their changes.
+func (i *Interpolator) Interpolate(sources []*Source) ([]*Source, error) {
+	sources, definitions, err := extractDefinitions(sources)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := i.variables(definitions)
+	if err != nil {
+		return nil, err
+	}
+
+	interpolated := make([]*Source, len(sources))
+	for n, source := range sources {
+		data, err := interpolate(source.Data, vars, i.Strict)
+		if err != nil {
+			return nil, fmt.Errorf("unable to interpolate %s: %s", source.Origin, err)
+		}
+		interpolated[n] = &Source{Name: source.Name, Origin: source.Origin, Data: data, Removed: source.Removed}
+	}
+	return interpolated, nil
+}
+
+func interpolate(document map[string]interface{}, vars template.Variables, strict bool) (map[string]interface{}, error) {
+	in, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(in, []byte("((")) {
+		return document, nil
+	}
+
+	out, err := template.NewTemplate(in).Evaluate(vars, nil, template.EvaluateOpts{ExpectAllKeys: strict})
+	if err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	if err := yaml.Unmarshal(out, &data); err != nil {
+		return nil, err
+	}
+	interpolated, _ := normalize(data).(map[string]interface{})
+	if interpolated == nil {
+		interpolated = map[string]interface{}{}
+	}
+	return interpolated, nil
+}
+
+func (i *Interpolator) variables(definitions map[string]VariableDefinition) (template.Variables, error) {
+	prefix := i.EnvPrefix
+	if prefix == "" {
+		prefix = DefaultVarsEnvPrefix
+	}
+	env := template.VarsEnvArg{EnvironFunc: i.Environ}
+	if err := env.UnmarshalFlag(prefix); err != nil {
+		return nil, err
+	}
+	vars := []template.Variables{env.Vars}
+
+	for n := len(i.VarsFiles) - 1; n >= 0; n-- {
+		fileVars, err := readVarsFile(i.VarsFiles[n])
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, fileVars)
+	}
+
+	for n := len(i.Stores) - 1; n >= 0; n-- {
+		vars = append(vars, storeVariables{i.Stores[n]})
+	}
+
+	generated := &generatedVariables{
+		store:       i.VarsStore,
+		definitions: definitions,
+		values:      map[string]interface{}{},
+		generating:  map[string]bool{},
+	}
+	multi := template.NewMultiVars(append(vars, generated))
+	generated.lookup = multi
+	return multi, nil
+}
+
+func readVarsFile(path string) (template.StaticVariables, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("vars file %s does not exist", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var vars template.StaticVariables
+	if err := yaml.Unmarshal(contents, &vars); err != nil {
+		return nil, fmt.Errorf("unable to parse vars file %s: %s", path, err)
+	}
+	return vars, nil
+}
+
+// storeVariables adapts a VariableStore to the template package.
+type storeVariables struct {
+	store VariableStore
+}
+
+func (v storeVariables) Get(def template.VariableDefinition) (interface{}, bool, error) {
+	value, found, err := v.store.Variable(def.Name)
+	return yamlValue(value), found, err
+}
+
+func (v storeVariables) List() ([]template.VariableDefinition, error) {
+	return nil, nil
+}
+
+// yamlValue converts decoded JSON to the map[interface{}]interface{} form
+// the template package expects when selecting ((name.key)).
+func yamlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			m[key] = yamlValue(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = yamlValue(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,129 @@
+package configserver_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// variableStore resolves variables from a map, like a secret backend.
+type variableStore map[string]interface{}
+
+func (s variableStore) Variable(name string) (interface{}, bool, error) {
+	if name == "broken" {
+		return nil, false, errors.New("permission denied")
+	}
+	value, found := s[name]
+	return value, found, nil
+}
+
+var _ = Describe("Interpolator", func() {
+	var (
+		dir          string
+		interpolator *configserver.Interpolator
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "configserver")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "base.yml"), []byte("region: eu\ndb:\n  host: localhost\n  port: 5432\n"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "override.yml"), []byte("region: us\n"), 0644)).To(Succeed())
+
+		interpolator = &configserver.Interpolator{
+			EnvPrefix: "VARS",
+			Environ:   func() []string { return []string{"VARS_tier=gold", "VARS_region=ap", "OTHER_tier=silver"} },
+			VarsFiles: []string{filepath.Join(dir, "base.yml"), filepath.Join(dir, "override.yml")},
+			Stores: []configserver.VariableStore{variableStore{
+				"password": "s3cret",
+				"tier":     "bronze",
+				"api":      map[string]interface{}{"key": "abc"},
+			}},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	interpolate := func(data map[string]interface{}) (map[string]interface{}, error) {
+		sources, err := interpolator.Interpolate([]*configserver.Source{{Name: "application", Origin: "test:application", Data: data}})
+		if err != nil {
+			return nil, err
+		}
+		return sources[0].Data, nil
+	}
+
+	It("resolves variables from vars files, the environment and stores", func() {
+		Expect(interpolate(map[string]interface{}{
+			"url":      "postgres://((db.host)):((db.port))/app",
+			"port":     "((db.port))",
+			"password": "((password))",
+			"api_key":  "((api.key))",
+			"nested":   []interface{}{map[string]interface{}{"tier": "((tier))"}},
+		})).To(Equal(map[string]interface{}{
+			"url":      "postgres://localhost:5432/app",
+			"port":     5432,
+			"password": "s3cret",
+			"api_key":  "abc",
+			"nested":   []interface{}{map[string]interface{}{"tier": "gold"}},
+		}))
+	})
+
+	It("prefers the environment, then later vars files, then stores", func() {
+		Expect(interpolate(map[string]interface{}{"region": "((region))", "tier": "((tier))"})).To(Equal(map[string]interface{}{
+			"region": "ap",
+			"tier":   "gold",
+		}))
+
+		interpolator.Environ = func() []string { return nil }
+		Expect(interpolate(map[string]interface{}{"region": "((region))", "tier": "((tier))"})).To(Equal(map[string]interface{}{
+			"region": "us",
+			"tier":   "bronze",
+		}))
+	})
+
+	It("leaves unresolved variables as they are", func() {
+		Expect(interpolate(map[string]interface{}{"token": "((missing))"})).To(Equal(map[string]interface{}{"token": "((missing))"}))
+	})
+
+	It("fails on unresolved variables in strict mode", func() {
+		interpolator.Strict = true
+		_, err := interpolate(map[string]interface{}{"token": "((missing))", "other": "((also_missing))"})
+		Expect(err).To(MatchError(ContainSubstring("unable to interpolate test:application")))
+		Expect(err).To(MatchError(ContainSubstring("missing")))
+		Expect(err).To(MatchError(ContainSubstring("also_missing")))
+	})
+
+	It("fails when a store fails", func() {
+		_, err := interpolate(map[string]interface{}{"token": "((broken))"})
+		Expect(err).To(MatchError(ContainSubstring("permission denied")))
+	})
+
+	It("fails when a vars file is missing", func() {
+		interpolator.VarsFiles = []string{filepath.Join(dir, "missing.yml")}
+		_, err := interpolate(map[string]interface{}{})
+		Expect(err).To(MatchError(ContainSubstring("missing.yml does not exist")))
+	})
+
+	It("keeps serving the previous documents when a strict reload fails", func() {
+		backend := &flakyBackend{sources: []*configserver.Source{
+			{Name: "application", Origin: "flaky:application", Data: map[string]interface{}{"password": "((password))"}},
+		}}
+		server := configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+		server.Interpolator = interpolator
+		interpolator.Strict = true
+		Expect(server.Load()).To(Succeed())
+
+		backend.sources[0].Data = map[string]interface{}{"password": "((typo))"}
+		Expect(server.Load()).To(MatchError(ContainSubstring("typo")))
+		Expect(server.Status().Ready).To(BeFalse())
+	})
+})
@@ -0,0 +1,115 @@
+package configserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// labeled reports whether any backend serves documents by label.
+func (s *Server) labeled() bool {
+	for _, backend := range s.backends {
+		if _, ok := backend.(LabeledBackend); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// labelSources returns the sources at label. The loaded sources are
+// returned when label is empty or no backend is labeled, so that labels
+// are ignored as Spring's native backend ignores them. Sources at other
+// labels are loaded on first use and kept until the next successful Load,
+// keyed by the revisions the label selects, so that the many labels naming
+// the same commit share one copy.
+func (s *Server) labelSources(label string) ([]*Source, error) {
+	if label == "" || !s.labeled() {
+		return s.Sources(), nil
+	}
+	labels := map[Backend]string{}
+	var revisions []string
+	for _, backend := range s.backends {
+		labeled, ok := backend.(LabeledBackend)
+		if !ok {
+			continue
+		}
+		revision, err := labeled.Revision(label)
+		if notFound, ok := err.(*LabelNotFoundError); ok {
+			return nil, notFound
+		}
+		if err != nil {
+			return nil, &labelError{label: label, err: &BackendError{Backend: backend.Name(), Err: err}}
+		}
+		labels[backend] = revision
+		revisions = append(revisions, revision)
+	}
+	key := strings.Join(revisions, ",")
+
+	s.mu.RLock()
+	sources, ok := s.labels[key]
+	s.mu.RUnlock()
+	if ok {
+		return sources, nil
+	}
+
+	sources, err := s.loadSources(labels)
+	if backendErr, ok := err.(*BackendError); ok {
+		if notFound, ok := backendErr.Err.(*LabelNotFoundError); ok {
+			return nil, notFound
+		}
+	}
+	if err != nil {
+		return nil, &labelError{label: label, err: err}
+	}
+	s.mu.Lock()
+	if s.labels != nil {
+		s.labels[key] = sources
+	}
+	s.mu.Unlock()
+	return sources, nil
+}
+
+// labelDocument returns the merged and decrypted document for name and
+// profiles at label. Without a label it is resolveDocument.
+func (s *Server) labelDocument(name string, profiles []string, label string) (*resolvedDocument, bool, error) {
+	if label == "" || !s.labeled() {
+		return s.resolveDocument(name, profiles)
+	}
+	sources, err := s.labelSources(label)
+	if err != nil {
+		return nil, false, err
+	}
+	merged, found := Resolve(sources, name, profiles)
+	document, err := s.Cipher.DecryptDocument(MergeSources(merged))
+	if err != nil {
+		return nil, found, err
+	}
+	resolved, err := newResolvedDocument(document)
+	return resolved, found, err
+}
+
+// labelError is a failure to load the sources at a label.
+type labelError struct {
+	label string
+	err   error
+}
+
+func (e *labelError) Error() string {
+	return fmt.Sprintf("unable to load config at label %q: %s", e.label, e.err)
+}
+
+// labelFailed reports whether err is a failure to load the sources at
+// label, in which case it writes the error response.
+func (s *Server) labelFailed(res http.ResponseWriter, label string, err error) bool {
+	switch err.(type) {
+	case *LabelNotFoundError:
+		s.Log.Printf("Received a request for unknown label %q.", label)
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return true
+	case *labelError:
+		s.Log.Printf("%s", err)
+		http.Error(res, fmt.Sprintf("unable to load config at label %q", label), http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
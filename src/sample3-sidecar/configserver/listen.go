@@ -0,0 +1,39 @@
+package configserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// Listen opens the listener selected by settings: a Unix domain socket
+// readable only by the app user when Socket is set, otherwise TCP on Port.
+func Listen(settings Settings) (net.Listener, error) {
+	if settings.Socket == "" {
+		return net.Listen("tcp", ":"+settings.Port)
+	}
+	return ListenUnix(settings.Socket)
+}
+
+// ListenUnix listens on a Unix domain socket at path with mode 0600,
+// replacing a stale socket left behind by a previous run.
+func ListenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,83 @@
+package configserver_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Listen", func() {
+	var (
+		dir    string
+		socket string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "configserver")
+		Expect(err).NotTo(HaveOccurred())
+		socket = filepath.Join(dir, "config-server.sock")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("listens on a unix socket only the app user can use", func() {
+		listener, err := configserver.Listen(configserver.Settings{Port: "8082", Socket: socket})
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		info, err := os.Stat(socket)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode() & os.ModeSocket).NotTo(BeZero())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		server := newServer(&configserver.Source{Name: "application", Origin: "test", Data: map[string]interface{}{"key": "value"}})
+		go http.Serve(listener, server.Handler())
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}}
+		res, err := client.Get("http://config-server/config/")
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(MatchJSON(`{"key":"value"}`))
+	})
+
+	It("replaces a stale socket", func() {
+		stale, err := configserver.ListenUnix(socket)
+		Expect(err).NotTo(HaveOccurred())
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		Expect(stale.Close()).To(Succeed())
+
+		listener, err := configserver.ListenUnix(socket)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listener.Close()).To(Succeed())
+	})
+
+	It("refuses to replace a file that is not a socket", func() {
+		Expect(ioutil.WriteFile(socket, []byte("data"), 0644)).To(Succeed())
+
+		_, err := configserver.ListenUnix(socket)
+		Expect(err).To(MatchError(ContainSubstring("is not a socket")))
+	})
+
+	It("listens on TCP by default", func() {
+		listener, err := configserver.Listen(configserver.Settings{Port: "0"})
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		Expect(listener.Addr().Network()).To(Equal("tcp"))
+	})
+})
@@ -0,0 +1,43 @@
+package configserver
+
+// Merge deep-merges the given documents into a new document. Nested mappings
+// are merged key by key; any other value in a later document replaces the
+// value from an earlier one. The inputs are never modified.
+func Merge(documents ...map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for _, document := range documents {
+		mergeInto(merged, document)
+	}
+	return merged
+}
+
+func mergeInto(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeInto(dstMap, srcMap)
+			continue
+		}
+		dst[key] = deepCopy(value)
+	}
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = deepCopy(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = deepCopy(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
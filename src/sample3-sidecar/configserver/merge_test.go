@@ -0,0 +1,33 @@
+package configserver_test
+
+import (
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Merge", func() {
+	It("deep merges mappings with later documents taking precedence", func() {
+		base := map[string]interface{}{
+			"db":    map[string]interface{}{"host": "localhost", "port": 5432},
+			"flags": []interface{}{"a"},
+		}
+		overlay := map[string]interface{}{
+			"db":    map[string]interface{}{"host": "db.internal"},
+			"flags": []interface{}{"b"},
+		}
+
+		Expect(configserver.Merge(base, overlay)).To(Equal(map[string]interface{}{
+			"db":    map[string]interface{}{"host": "db.internal", "port": 5432},
+			"flags": []interface{}{"b"},
+		}))
+	})
+
+	It("does not modify its inputs", func() {
+		base := map[string]interface{}{"db": map[string]interface{}{"host": "localhost"}}
+		configserver.Merge(base, map[string]interface{}{"db": map[string]interface{}{"host": "other"}})
+
+		Expect(base).To(Equal(map[string]interface{}{"db": map[string]interface{}{"host": "localhost"}}))
+	})
+})
@@ -0,0 +1,225 @@
+package configserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram.
+var LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+type requestKey struct {
+	route string
+	code  int
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// metrics counts what the server does and writes it in the Prometheus text
+// exposition format.
+type metrics struct {
+	mu            sync.Mutex
+	requests      map[requestKey]uint64
+	latencies     map[string]*histogram
+	backendErrors map[string]uint64
+	reloads       map[string]uint64
+	cacheHits     uint64
+	cacheMisses   uint64
+}
+
+func newMetrics(backends []Backend) *metrics {
+	m := &metrics{
+		requests:      map[requestKey]uint64{},
+		latencies:     map[string]*histogram{},
+		backendErrors: map[string]uint64{},
+		reloads:       map[string]uint64{"success": 0, "failure": 0},
+	}
+	for _, backend := range backends {
+		m.backendErrors[backend.Name()] = 0
+	}
+	return m
+}
+
+func (m *metrics) observeRequest(route string, code int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{route, code}]++
+
+	h, ok := m.latencies[route]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(LatencyBuckets))}
+		m.latencies[route] = h
+	}
+	seconds := elapsed.Seconds()
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *metrics) observeReload(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		m.reloads["success"]++
+		return
+	}
+	m.reloads["failure"]++
+	if backendErr, ok := err.(*BackendError); ok {
+		m.backendErrors[backendErr.Backend]++
+	}
+}
+
+func (m *metrics) observeCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// write writes every metric in the Prometheus text format, with series
+// sorted so the output is stable.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, "config_server_requests_total", "counter", "HTTP requests served, by route and status code.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "config_server_requests_total{route=%s,code=\"%d\"} %d\n", quoteLabel(key.route), key.code, m.requests[key])
+	}
+
+	writeMetricHeader(w, "config_server_request_duration_seconds", "histogram", "HTTP request latency, by route.")
+	routes := make([]string, 0, len(m.latencies))
+	for route := range m.latencies {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		h := m.latencies[route]
+		for i, bound := range LatencyBuckets {
+			fmt.Fprintf(w, "config_server_request_duration_seconds_bucket{route=%s,le=\"%s\"} %d\n", quoteLabel(route), formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "config_server_request_duration_seconds_bucket{route=%s,le=\"+Inf\"} %d\n", quoteLabel(route), h.count)
+		fmt.Fprintf(w, "config_server_request_duration_seconds_sum{route=%s} %s\n", quoteLabel(route), formatFloat(h.sum))
+		fmt.Fprintf(w, "config_server_request_duration_seconds_count{route=%s} %d\n", quoteLabel(route), h.count)
+	}
+
+	writeMetricHeader(w, "config_server_backend_errors_total", "counter", "Failed loads, by backend.")
+	for _, name := range sortedCounters(m.backendErrors) {
+		fmt.Fprintf(w, "config_server_backend_errors_total{backend=%s} %d\n", quoteLabel(name), m.backendErrors[name])
+	}
+
+	writeMetricHeader(w, "config_server_reloads_total", "counter", "Reloads of every backend, by result.")
+	for _, result := range sortedCounters(m.reloads) {
+		fmt.Fprintf(w, "config_server_reloads_total{result=%s} %d\n", quoteLabel(result), m.reloads[result])
+	}
+
+	writeMetricHeader(w, "config_server_cache_hits_total", "counter", "Documents served from the resolved document cache.")
+	fmt.Fprintf(w, "config_server_cache_hits_total %d\n", m.cacheHits)
+	writeMetricHeader(w, "config_server_cache_misses_total", "counter", "Documents resolved because they were not cached.")
+	fmt.Fprintf(w, "config_server_cache_misses_total %d\n", m.cacheMisses)
+
+	ratio := 0.0
+	if total := m.cacheHits + m.cacheMisses; total > 0 {
+		ratio = float64(m.cacheHits) / float64(total)
+	}
+	writeMetricHeader(w, "config_server_cache_hit_ratio", "gauge", "Fraction of document lookups served from the cache.")
+	fmt.Fprintf(w, "config_server_cache_hit_ratio %s\n", formatFloat(ratio))
+}
+
+// instrument records the count and latency of every request, labelled by
+// the mux pattern that served it.
+func (s *Server) instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		_, route := mux.Handler(req)
+		switch {
+		case route == "":
+			route = "unmatched"
+		case route == "/config/" && strings.HasSuffix(strings.TrimSuffix(req.URL.Path, "/"), "/watch"):
+			// Keep long-lived streams out of the document latencies.
+			route = "/config/*/watch"
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: res, code: http.StatusOK}
+		mux.ServeHTTP(recorder, req)
+		s.metrics.observeRequest(route, recorder.code, time.Since(start))
+	})
+}
+
+// metricsHandler serves the metrics in the Prometheus text format.
+func (s *Server) metricsHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.write(res)
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets watch streams flush through the recorder.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func quoteLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return `"` + strings.Replace(value, `"`, `\"`, -1) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedCounters(counters map[string]uint64) []string {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,99 @@
+package configserver_test
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		backend *flakyBackend
+		server  *configserver.Server
+	)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		return res
+	}
+
+	BeforeEach(func() {
+		backend = &flakyBackend{sources: []*configserver.Source{
+			{Name: "application", Origin: "flaky:application", Data: map[string]interface{}{"Password": "secret"}},
+		}}
+		server = configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+		Expect(server.Load()).To(Succeed())
+	})
+
+	It("serves metrics in the Prometheus text format without a token", func() {
+		server.Token = "s3cret"
+
+		res := get("/metrics")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+		Expect(res.Body.String()).To(ContainSubstring("# TYPE config_server_requests_total counter\n"))
+		Expect(res.Body.String()).To(ContainSubstring("# TYPE config_server_request_duration_seconds histogram\n"))
+	})
+
+	It("counts requests by route and status", func() {
+		get("/config/")
+		get("/config/")
+		get("/config/missing")
+		get("/healthz")
+
+		body := get("/metrics").Body.String()
+		Expect(body).To(ContainSubstring(`config_server_requests_total{route="/config/",code="200"} 2` + "\n"))
+		Expect(body).To(ContainSubstring(`config_server_requests_total{route="/config/",code="404"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`config_server_requests_total{route="/healthz",code="200"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`config_server_request_duration_seconds_bucket{route="/config/",le="+Inf"} 3` + "\n"))
+		Expect(body).To(ContainSubstring(`config_server_request_duration_seconds_count{route="/config/"} 3` + "\n"))
+	})
+
+	It("counts rejected requests under the route they were made to", func() {
+		server.Token = "s3cret"
+		get("/config/")
+
+		Expect(get("/metrics").Body.String()).To(ContainSubstring(`config_server_requests_total{route="/config/",code="401"} 1` + "\n"))
+	})
+
+	It("counts reloads and backend errors", func() {
+		backend.loadErr = errors.New("connection refused")
+		Expect(server.Load()).NotTo(Succeed())
+
+		body := get("/metrics").Body.String()
+		Expect(body).To(ContainSubstring(`config_server_reloads_total{result="success"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`config_server_reloads_total{result="failure"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`config_server_backend_errors_total{backend="flaky"} 1` + "\n"))
+	})
+
+	It("reports the document cache hit ratio", func() {
+		get("/config/")
+		get("/config/")
+		get("/config/")
+		body := get("/metrics").Body.String()
+		Expect(body).To(ContainSubstring("config_server_cache_hits_total 2\n"))
+		Expect(body).To(ContainSubstring("config_server_cache_misses_total 1\n"))
+
+		Expect(server.Load()).To(Succeed())
+		get("/config/")
+		body = get("/metrics").Body.String()
+		Expect(body).To(ContainSubstring("config_server_cache_misses_total 2\n"))
+		Expect(body).To(ContainSubstring("config_server_cache_hit_ratio 0.5\n"))
+	})
+
+	It("serves documents from the cache until the next load", func() {
+		Expect(get("/config/").Body.String()).To(MatchJSON(`{"Password":"secret"}`))
+
+		backend.sources[0].Data = map[string]interface{}{"Password": "rotated"}
+		Expect(get("/config/").Body.String()).To(MatchJSON(`{"Password":"secret"}`))
+
+		Expect(server.Load()).To(Succeed())
+		Expect(get("/config/").Body.String()).To(MatchJSON(`{"Password":"rotated"}`))
+	})
+})
@@ -0,0 +1,265 @@
+package configserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/cppforlife/go-patch/patch"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// OpsFiles applies go-patch ops files to the loaded documents. The ops
+// operate on a tree holding every document under its name, so an op's path
+// starts with the document it changes:
+//
+//   - type: replace
+//     path: /billing/db/pool
+//     value: 20
+type OpsFiles struct {
+	// Patterns are applied in order, with the files matched by a glob
+	// sorted. A pattern without glob characters must match a file.
+	Patterns []string
+}
+
+// Files returns the ops files matched by the patterns, in the order they
+// are applied.
+func (o *OpsFiles) Files() ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	for _, pattern := range o.Patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ops file pattern %q: %s", pattern, err)
+		}
+		if len(matches) == 0 && !hasGlobMeta(pattern) {
+			return nil, fmt.Errorf("ops file %s does not exist", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// Apply applies every ops file to sources. The sources are kept as they
+// are, except that keys the ops remove are removed from them, and each op
+// that changes a document adds a source to it holding the values the op
+// set and listing the keys it removed, with the origin
+// "ops:<file>:<path>". Sources of documents the ops remove are dropped.
+func (o *OpsFiles) Apply(sources []*Source) ([]*Source, error) {
+	files, err := o.Files()
+	if err != nil || len(files) == 0 {
+		return sources, err
+	}
+
+	var names []string
+	byName := map[string][]*Source{}
+	for _, source := range sources {
+		if byName[source.Name] == nil {
+			names = append(names, source.Name)
+		}
+		byName[source.Name] = append(byName[source.Name], source)
+	}
+
+	var tree interface{} = map[interface{}]interface{}{}
+	for _, name := range names {
+		tree.(map[interface{}]interface{})[name] = yamlValue(MergeSources(byName[name]))
+	}
+	documents, _ := normalize(tree).(map[string]interface{})
+
+	for _, file := range files {
+		ops, paths, err := readOpsFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for i, op := range ops {
+			if tree, err = op.Apply(tree); err != nil {
+				return nil, fmt.Errorf("unable to apply ops file %s: %s", file, err)
+			}
+			patched, err := opsDocuments(file, tree)
+			if err != nil {
+				return nil, err
+			}
+
+			origin := "ops:" + file + ":" + paths[i]
+			for _, name := range changedDocuments(documents, patched) {
+				if _, kept := patched[name]; !kept {
+					delete(byName, name)
+					continue
+				}
+				if byName[name] == nil {
+					names = append(names, name)
+				}
+				before, _ := documents[name].(map[string]interface{})
+				set, removed := diffDocument(before, patched[name].(map[string]interface{}), nil)
+				byName[name] = append(withoutKeys(byName[name], removed), &Source{
+					Name:    name,
+					Origin:  origin,
+					Data:    set,
+					Removed: flattenPaths(before, removed),
+				})
+			}
+			documents = patched
+		}
+	}
+
+	var result []*Source
+	for _, name := range names {
+		result = append(result, byName[name]...)
+		delete(byName, name)
+	}
+	return result, nil
+}
+
+// opsDocuments returns the documents in the tree patched by file.
+func opsDocuments(file string, tree interface{}) (map[string]interface{}, error) {
+	documents, ok := normalize(tree).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ops file %s replaced the documents with a %T", file, tree)
+	}
+	for name, value := range documents {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("ops file %s turned document %q into a %T", file, name, value)
+		}
+	}
+	return documents, nil
+}
+
+// changedDocuments returns the sorted names of the documents that differ
+// between before and after.
+func changedDocuments(before, after map[string]interface{}) []string {
+	var names []string
+	for name, document := range after {
+		if !reflect.DeepEqual(document, before[name]) {
+			names = append(names, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// diffDocument returns the values in after that are not in before, as a
+// document merging over before, and the paths of the keys in before that
+// after does not have. Mappings are compared key by key, any other value
+// as a whole.
+func diffDocument(before, after map[string]interface{}, path []string) (map[string]interface{}, [][]string) {
+	set := map[string]interface{}{}
+	var removed [][]string
+	for key, value := range after {
+		previous, found := before[key]
+		previousMap, previousIsMap := previous.(map[string]interface{})
+		valueMap, valueIsMap := value.(map[string]interface{})
+		switch {
+		case found && previousIsMap && valueIsMap:
+			childSet, childRemoved := diffDocument(previousMap, valueMap, append(path[:len(path):len(path)], key))
+			if len(childSet) > 0 {
+				set[key] = childSet
+			}
+			removed = append(removed, childRemoved...)
+		case !found || !reflect.DeepEqual(previous, value):
+			set[key] = value
+		}
+	}
+	for key := range before {
+		if _, found := after[key]; !found {
+			removed = append(removed, append(path[:len(path):len(path)], key))
+		}
+	}
+	return set, removed
+}
+
+// withoutKeys returns sources with the keys at paths removed, copying the
+// sources and mappings it changes.
+func withoutKeys(sources []*Source, paths [][]string) []*Source {
+	if len(paths) == 0 {
+		return sources
+	}
+	result := make([]*Source, len(sources))
+	for i, source := range sources {
+		data := source.Data
+		for _, path := range paths {
+			data = withoutKey(data, path)
+		}
+		result[i] = source
+		if !reflect.DeepEqual(data, source.Data) {
+			copied := *source
+			copied.Data = data
+			result[i] = &copied
+		}
+	}
+	return result
+}
+
+func withoutKey(data map[string]interface{}, path []string) map[string]interface{} {
+	value, found := data[path[0]]
+	if !found {
+		return data
+	}
+	copied := make(map[string]interface{}, len(data))
+	for key, item := range data {
+		copied[key] = item
+	}
+	if len(path) == 1 {
+		delete(copied, path[0])
+		return copied
+	}
+	child, ok := value.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	copied[path[0]] = withoutKey(child, path[1:])
+	return copied
+}
+
+// flattenPaths returns the flattened keys of the values at paths in data.
+func flattenPaths(data map[string]interface{}, paths [][]string) []string {
+	var keys []string
+	for _, path := range paths {
+		var value interface{} = data
+		for _, key := range path {
+			value = value.(map[string]interface{})[key]
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			value = map[string]interface{}{path[i]: value}
+		}
+		for key := range Flatten(value.(map[string]interface{})) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readOpsFile returns the ops in path and the path each op changes.
+func readOpsFile(path string) (patch.Ops, []string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read ops file %s: %s", path, err)
+	}
+
+	var definitions []patch.OpDefinition
+	if err := yaml.Unmarshal(contents, &definitions); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse ops file %s: %s", path, err)
+	}
+	ops, err := patch.NewOpsFromDefinitions(definitions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse ops file %s: %s", path, err)
+	}
+	paths := make([]string, len(definitions))
+	for i, definition := range definitions {
+		paths[i] = *definition.Path
+	}
+	return ops, paths, nil
+}
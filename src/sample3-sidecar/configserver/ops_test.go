@@ -0,0 +1,122 @@
+package configserver_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpsFiles", func() {
+	var (
+		dir     string
+		sources []*configserver.Source
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "configserver")
+		Expect(err).NotTo(HaveOccurred())
+
+		sources = []*configserver.Source{
+			{Name: "application", Origin: "file:application.yml", Data: map[string]interface{}{"region": "eu"}},
+			{Name: "billing", Origin: "file:billing.yml", Data: map[string]interface{}{"db": map[string]interface{}{"pool": 5.0}}},
+			{Name: "billing", Origin: "env:BILLING_", Data: map[string]interface{}{"db": map[string]interface{}{"host": "db"}}},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	writeOps := func(name, ops string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(ops), 0644)).To(Succeed())
+		return path
+	}
+
+	It("patches the documents named by the op paths", func() {
+		writeOps("1-pool.yml", "- type: replace\n  path: /billing/db/pool\n  value: 20\n")
+		writeOps("2-audit.yml", "- type: replace\n  path: /audit?/enabled\n  value: true\n")
+
+		patched, err := (&configserver.OpsFiles{Patterns: []string{filepath.Join(dir, "*.yml")}}).Apply(sources)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched).To(HaveLen(5))
+
+		Expect(patched[:3]).To(Equal(sources))
+		Expect(patched[3]).To(Equal(&configserver.Source{
+			Name:   "billing",
+			Origin: "ops:" + filepath.Join(dir, "1-pool.yml") + ":/billing/db/pool",
+			Data:   map[string]interface{}{"db": map[string]interface{}{"pool": 20}},
+		}))
+		Expect(configserver.MergeSources(patched[1:4])).To(Equal(map[string]interface{}{"db": map[string]interface{}{"pool": 20, "host": "db"}}))
+		Expect(patched[4]).To(Equal(&configserver.Source{
+			Name:   "audit",
+			Origin: "ops:" + filepath.Join(dir, "2-audit.yml") + ":/audit?/enabled",
+			Data:   map[string]interface{}{"enabled": true},
+		}))
+	})
+
+	It("removes the keys removed by an op from every source", func() {
+		file := writeOps("remove.yml", "- type: remove\n  path: /billing/db/host\n- type: replace\n  path: /billing/db/pool\n  value: 10\n- type: remove\n  path: /billing/db/pool\n")
+
+		patched, err := (&configserver.OpsFiles{Patterns: []string{file}}).Apply(sources)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched).To(HaveLen(6))
+
+		Expect(patched[0]).To(Equal(sources[0]))
+		Expect(patched[1].Data).To(Equal(map[string]interface{}{"db": map[string]interface{}{}}))
+		Expect(patched[2].Data).To(Equal(map[string]interface{}{"db": map[string]interface{}{}}))
+		Expect(patched[3]).To(Equal(&configserver.Source{
+			Name:    "billing",
+			Origin:  "ops:" + file + ":/billing/db/host",
+			Data:    map[string]interface{}{},
+			Removed: []string{"db.host"},
+		}))
+		// The pool set by the second op is removed by the third.
+		Expect(patched[4].Origin).To(Equal("ops:" + file + ":/billing/db/pool"))
+		Expect(patched[4].Data).To(Equal(map[string]interface{}{"db": map[string]interface{}{}}))
+		Expect(patched[5].Removed).To(Equal([]string{"db.pool"}))
+		Expect(configserver.MergeSources(patched[1:])).To(Equal(map[string]interface{}{"db": map[string]interface{}{}}))
+
+		// The sources loaded by the backends are left untouched.
+		Expect(sources[2].Data).To(Equal(map[string]interface{}{"db": map[string]interface{}{"host": "db"}}))
+	})
+
+	It("drops documents removed by an op", func() {
+		file := writeOps("remove.yml", "- type: remove\n  path: /billing\n")
+
+		patched, err := (&configserver.OpsFiles{Patterns: []string{file}}).Apply(sources)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched).To(Equal(sources[:1]))
+	})
+
+	It("ignores globs matching no files", func() {
+		patched, err := (&configserver.OpsFiles{Patterns: []string{filepath.Join(dir, "production", "*.yml")}}).Apply(sources)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched).To(Equal(sources))
+	})
+
+	It("fails on missing files and ops that do not apply", func() {
+		missing := filepath.Join(dir, "missing.yml")
+		_, err := (&configserver.OpsFiles{Patterns: []string{missing}}).Apply(sources)
+		Expect(err).To(MatchError("ops file " + missing + " does not exist"))
+
+		file := writeOps("bad.yml", "- type: replace\n  path: /billing/cache/host\n  value: redis\n")
+		_, err = (&configserver.OpsFiles{Patterns: []string{file}}).Apply(sources)
+		Expect(err).To(MatchError(ContainSubstring("unable to apply ops file " + file)))
+
+		file = writeOps("invalid.yml", "- type: rename\n  path: /billing\n")
+		_, err = (&configserver.OpsFiles{Patterns: []string{file}}).Apply(sources)
+		Expect(err).To(MatchError(ContainSubstring("unable to parse ops file " + file)))
+	})
+
+	It("refuses to turn a document into a scalar", func() {
+		file := writeOps("scalar.yml", "- type: replace\n  path: /billing\n  value: off\n")
+		_, err := (&configserver.OpsFiles{Patterns: []string{file}}).Apply(sources)
+		Expect(err).To(MatchError(ContainSubstring(`turned document "billing" into a bool`)))
+	})
+})
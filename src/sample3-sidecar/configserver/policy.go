@@ -0,0 +1,291 @@
+package configserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/tidwall/match"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultPolicyFile is the access policy read when $CONFIG_SERVER_POLICY is
+// not set. The sidecar serves every key to every caller if it is absent.
+const DefaultPolicyFile = "config/access-policy.yml"
+
+// WebIdentity is the identity of the web process, which authenticates with
+// $CONFIG_SERVER_TOKEN.
+const WebIdentity = "web"
+
+// RedactedValue replaces denied values when the policy redacts rather than
+// omits them.
+const RedactedValue = "<redacted>"
+
+// Policy restricts the keys each caller may read. Callers are identified
+// by their bearer token. Keys are matched as "<document>.<key>", using the
+// flattened key of a value such as billing.db.hosts[0], against
+// tidwall/match glob patterns where * matches any run of characters,
+// dots included, and ? matches one character.
+type Policy struct {
+	// Redact replaces denied values with RedactedValue instead of omitting
+	// them.
+	Redact     bool        `yaml:"redact"`
+	Identities []*Identity `yaml:"-"`
+}
+
+// Identity is a caller and the keys it may read. A key is allowed when it
+// matches an Allow pattern and no Deny pattern. Admins may also pin
+// documents to a version. The caller presents Token, or a token whose
+// SHA-256 digest is TokenSHA256, hex encoded, when that is set.
+type Identity struct {
+	Name        string   `yaml:"-"`
+	Token       string   `yaml:"-"`
+	TokenSHA256 string   `yaml:"-"`
+	Allow       []string `yaml:"allow"`
+	Deny        []string `yaml:"deny"`
+	Admin       bool     `yaml:"admin"`
+}
+
+// TokenVariable returns the environment variable holding the token of an
+// identity: $CONFIG_SERVER_TOKEN for web, and $CONFIG_SERVER_TOKEN_<NAME>
+// for others, with the name upper cased and other characters replaced by _.
+func TokenVariable(identity string) string {
+	if identity == WebIdentity {
+		return "CONFIG_SERVER_TOKEN"
+	}
+	return "CONFIG_SERVER_TOKEN_" + strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, identity)
+}
+
+// TokenHashVariable returns the environment variable holding the hex
+// encoded SHA-256 digest of the token of an identity, which is
+// TokenVariable with a _SHA256 suffix. Unlike the token, the digest can be
+// exported to every process without letting them authenticate as the
+// identity.
+func TokenHashVariable(identity string) string {
+	return TokenVariable(identity) + "_SHA256"
+}
+
+// PolicyPath returns the access policy named by $CONFIG_SERVER_POLICY, or
+// else DefaultPolicyFile, resolved against $HOME, and whether it must
+// exist because it was named.
+func PolicyPath(getenv func(string) string) (string, bool) {
+	path := firstNonEmpty(getenv("CONFIG_SERVER_POLICY"), DefaultPolicyFile)
+	return resolvePaths(getenv("HOME"), []string{path})[0], getenv("CONFIG_SERVER_POLICY") != ""
+}
+
+// ParsePolicy parses a policy file of the form
+//
+//	redact: false
+//	identities:
+//	  web:
+//	    allow: ["*"]
+//	    deny: ["*.admin_password"]
+//	  migrate:
+//	    allow: ["billing.db.*"]
+//
+// Identities are ordered by name and have no tokens set.
+func ParsePolicy(contents []byte) (*Policy, error) {
+	var file struct {
+		Policy     `yaml:",inline"`
+		Identities map[string]*Identity `yaml:"identities"`
+	}
+	if err := yaml.UnmarshalStrict(contents, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Identities) == 0 {
+		return nil, fmt.Errorf("no identities declared")
+	}
+
+	policy := file.Policy
+	for name, identity := range file.Identities {
+		if identity == nil {
+			identity = &Identity{}
+		}
+		identity.Name = name
+		policy.Identities = append(policy.Identities, identity)
+	}
+	sort.Slice(policy.Identities, func(i, j int) bool {
+		return policy.Identities[i].Name < policy.Identities[j].Name
+	})
+	return &policy, nil
+}
+
+// LoadPolicy returns the policy in settings.PolicyFile, with the token of
+// each identity read from its TokenVariable, or the digest of the token
+// from its TokenHashVariable. It returns nil when the file is the default
+// one and does not exist.
+func LoadPolicy(settings Settings, getenv func(string) string) (*Policy, error) {
+	contents, err := ioutil.ReadFile(settings.PolicyFile)
+	if os.IsNotExist(err) && !settings.PolicyRequired {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read access policy: %s", err)
+	}
+
+	policy, err := ParsePolicy(contents)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse access policy %s: %s", settings.PolicyFile, err)
+	}
+	for _, identity := range policy.Identities {
+		identity.Token = getenv(TokenVariable(identity.Name))
+		identity.TokenSHA256 = strings.ToLower(getenv(TokenHashVariable(identity.Name)))
+		if identity.TokenSHA256 != "" {
+			if digest, err := hex.DecodeString(identity.TokenSHA256); err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("access policy %s: $%s is not a hex encoded SHA-256 digest", settings.PolicyFile, TokenHashVariable(identity.Name))
+			}
+		} else if identity.Token == "" {
+			return nil, fmt.Errorf("access policy %s: missing $%s or $%s for identity %q", settings.PolicyFile, TokenVariable(identity.Name), TokenHashVariable(identity.Name), identity.Name)
+		}
+	}
+	return policy, nil
+}
+
+// Identify returns the identity holding token, or nil if there is none.
+func (p *Policy) Identify(token string) *Identity {
+	var found *Identity
+	// Every token is compared, so the time taken does not reveal which
+	// identity matched.
+	for _, identity := range p.Identities {
+		if identity.holds(token) && found == nil {
+			found = identity
+		}
+	}
+	return found
+}
+
+// holds reports whether token authenticates as the identity.
+func (i *Identity) holds(token string) bool {
+	if i.TokenSHA256 == "" {
+		return tokenMatches(token, i.Token)
+	}
+	digest := sha256.Sum256([]byte(token))
+	return tokenMatches(hex.EncodeToString(digest[:]), i.TokenSHA256)
+}
+
+// Allows reports whether the identity may read key in document.
+func (i *Identity) Allows(document, key string) bool {
+	name := document + "." + key
+	for _, pattern := range i.Deny {
+		if match.Match(name, pattern) {
+			return false
+		}
+	}
+	for _, pattern := range i.Allow {
+		if match.Match(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns a copy of data holding only the values identity may read
+// in document, with denied values omitted or redacted, and the flattened
+// keys that were denied. Mappings and lists left empty are omitted.
+func (p *Policy) Filter(identity *Identity, document string, data map[string]interface{}) (map[string]interface{}, []string) {
+	var denied []string
+	filtered, _ := p.filterValue(identity, document, "", data, &denied)
+	result, _ := filtered.(map[string]interface{})
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+	sort.Strings(denied)
+	return result, denied
+}
+
+func (p *Policy) filterValue(identity *Identity, document, key string, value interface{}, denied *[]string) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for child, item := range v {
+			path := child
+			if key != "" {
+				path = key + "." + child
+			}
+			if filtered, ok := p.filterValue(identity, document, path, item, denied); ok {
+				m[child] = filtered
+			}
+		}
+		return m, len(m) > 0 || len(v) == 0
+	case []interface{}:
+		var s []interface{}
+		for i, item := range v {
+			if filtered, ok := p.filterValue(identity, document, fmt.Sprintf("%s[%d]", key, i), item, denied); ok {
+				s = append(s, filtered)
+			}
+		}
+		if s == nil {
+			s = []interface{}{}
+		}
+		return s, len(s) > 0 || len(v) == 0
+	default:
+		if identity.Allows(document, key) {
+			return v, true
+		}
+		*denied = append(*denied, key)
+		return RedactedValue, p.Redact
+	}
+}
+
+type identityKey struct{}
+
+// identify authenticates a request against the policy, returning the
+// request with its identity attached.
+func (s *Server) identify(req *http.Request) (*http.Request, bool) {
+	identity := s.Policy.Identify(bearerToken(req))
+	if identity == nil {
+		return req, false
+	}
+	return req.WithContext(context.WithValue(req.Context(), identityKey{}, identity)), true
+}
+
+// identity returns the identity a request authenticated as, or nil when
+// there is no policy.
+func (s *Server) identity(req *http.Request) *Identity {
+	identity, _ := req.Context().Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// admin reports whether req may change what is served. Without a policy
+// every authenticated caller is an admin.
+func (s *Server) admin(req *http.Request) bool {
+	if s.Policy == nil {
+		return true
+	}
+	identity := s.identity(req)
+	return identity != nil && identity.Admin
+}
+
+// filter applies the policy to a document served to req, logging the keys
+// denied to its identity. Without a policy every key is served.
+func (s *Server) filter(req *http.Request, document string, data map[string]interface{}) map[string]interface{} {
+	identity := s.identity(req)
+	if s.Policy == nil || identity == nil {
+		return data
+	}
+	filtered, denied := s.Policy.Filter(identity, document, data)
+	if len(denied) > 0 {
+		s.Log.Printf("Denied %s access to %d keys of %q: %s", identity.Name, len(denied), document, strings.Join(denied, ", "))
+	}
+	return filtered
+}
+
+// authorize returns the resolved document as served to req.
+func (s *Server) authorize(req *http.Request, name string, resolved *resolvedDocument) (*resolvedDocument, error) {
+	if s.Policy == nil {
+		return resolved, nil
+	}
+	return newResolvedDocument(s.filter(req, name, resolved.document))
+}
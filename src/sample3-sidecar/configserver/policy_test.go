@@ -0,0 +1,195 @@
+package configserver_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const accessPolicy = `
+identities:
+  web:
+    allow: ["*"]
+    deny: ["*.admin_password"]
+  migrate:
+    allow: ["billing.db.*", "services.postgres.credentials.*"]
+`
+
+var _ = Describe("Policy", func() {
+	var policy *configserver.Policy
+
+	BeforeEach(func() {
+		var err error
+		policy, err = configserver.ParsePolicy([]byte(accessPolicy))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("ParsePolicy", func() {
+		It("lists the identities by name", func() {
+			Expect(policy.Identities).To(HaveLen(2))
+			Expect(policy.Identities[0].Name).To(Equal("migrate"))
+			Expect(policy.Identities[0].Allow).To(Equal([]string{"billing.db.*", "services.postgres.credentials.*"}))
+			Expect(policy.Identities[1].Name).To(Equal("web"))
+			Expect(policy.Redact).To(BeFalse())
+		})
+
+		It("rejects unknown fields and empty policies", func() {
+			_, err := configserver.ParsePolicy([]byte("identities:\n  web:\n    allowed: ['*']\n"))
+			Expect(err).To(HaveOccurred())
+
+			_, err = configserver.ParsePolicy([]byte("redact: true\n"))
+			Expect(err).To(MatchError("no identities declared"))
+		})
+	})
+
+	Describe("LoadPolicy", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "configserver")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "policy.yml"), []byte(accessPolicy), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("reads each identity's token from the environment", func() {
+			env := map[string]string{"CONFIG_SERVER_TOKEN": "web-token", "CONFIG_SERVER_TOKEN_MIGRATE": "migrate-token"}
+			policy, err := configserver.LoadPolicy(configserver.Settings{PolicyFile: filepath.Join(dir, "policy.yml")}, func(name string) string { return env[name] })
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy.Identify("migrate-token").Name).To(Equal("migrate"))
+			Expect(policy.Identify("web-token").Name).To(Equal("web"))
+			Expect(policy.Identify("other")).To(BeNil())
+
+			_, err = configserver.LoadPolicy(configserver.Settings{PolicyFile: filepath.Join(dir, "policy.yml")}, func(string) string { return "" })
+			Expect(err).To(MatchError(ContainSubstring(`missing $CONFIG_SERVER_TOKEN_MIGRATE or $CONFIG_SERVER_TOKEN_MIGRATE_SHA256 for identity "migrate"`)))
+		})
+
+		It("authenticates identities by the digest of their token", func() {
+			digest := sha256.Sum256([]byte("migrate-token"))
+			env := map[string]string{"CONFIG_SERVER_TOKEN": "web-token", "CONFIG_SERVER_TOKEN_MIGRATE_SHA256": strings.ToUpper(hex.EncodeToString(digest[:]))}
+			policy, err := configserver.LoadPolicy(configserver.Settings{PolicyFile: filepath.Join(dir, "policy.yml")}, func(name string) string { return env[name] })
+			Expect(err).NotTo(HaveOccurred())
+			Expect(policy.Identify("migrate-token").Name).To(Equal("migrate"))
+			Expect(policy.Identify(hex.EncodeToString(digest[:]))).To(BeNil())
+			Expect(policy.Identify("web-token").Name).To(Equal("web"))
+
+			env["CONFIG_SERVER_TOKEN_MIGRATE_SHA256"] = "migrate-token"
+			_, err = configserver.LoadPolicy(configserver.Settings{PolicyFile: filepath.Join(dir, "policy.yml")}, func(name string) string { return env[name] })
+			Expect(err).To(MatchError(ContainSubstring("$CONFIG_SERVER_TOKEN_MIGRATE_SHA256 is not a hex encoded SHA-256 digest")))
+		})
+
+		It("only requires the file when it is configured", func() {
+			settings := configserver.Settings{PolicyFile: filepath.Join(dir, "missing.yml")}
+			Expect(configserver.LoadPolicy(settings, os.Getenv)).To(BeNil())
+
+			settings.PolicyRequired = true
+			_, err := configserver.LoadPolicy(settings, os.Getenv)
+			Expect(err).To(MatchError(ContainSubstring("unable to read access policy")))
+		})
+	})
+
+	It("names token variables after identities", func() {
+		Expect(configserver.TokenVariable("web")).To(Equal("CONFIG_SERVER_TOKEN"))
+		Expect(configserver.TokenVariable("db-migrate")).To(Equal("CONFIG_SERVER_TOKEN_DB_MIGRATE"))
+		Expect(configserver.TokenHashVariable("db-migrate")).To(Equal("CONFIG_SERVER_TOKEN_DB_MIGRATE_SHA256"))
+	})
+
+	Describe("Filter", func() {
+		data := map[string]interface{}{
+			"db":     map[string]interface{}{"host": "db", "admin_password": "s3cret", "hosts": []interface{}{"db1", "db2"}},
+			"region": "eu",
+		}
+
+		It("omits denied keys", func() {
+			filtered, denied := policy.Filter(policy.Identities[1], "billing", data)
+			Expect(filtered).To(Equal(map[string]interface{}{
+				"db":     map[string]interface{}{"host": "db", "hosts": []interface{}{"db1", "db2"}},
+				"region": "eu",
+			}))
+			Expect(denied).To(Equal([]string{"db.admin_password"}))
+
+			filtered, denied = policy.Filter(policy.Identities[0], "application", data)
+			Expect(filtered).To(BeEmpty())
+			Expect(denied).To(HaveLen(5))
+		})
+
+		It("redacts denied keys when configured", func() {
+			policy.Redact = true
+			filtered, _ := policy.Filter(policy.Identities[0], "billing", data)
+			Expect(filtered).To(Equal(map[string]interface{}{
+				"db":     map[string]interface{}{"host": "db", "admin_password": "s3cret", "hosts": []interface{}{"db1", "db2"}},
+				"region": configserver.RedactedValue,
+			}))
+		})
+	})
+
+	Describe("serving", func() {
+		var (
+			server *configserver.Server
+			logs   *bytes.Buffer
+		)
+
+		get := func(path, token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			res := httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, req)
+			return res
+		}
+
+		BeforeEach(func() {
+			logs = &bytes.Buffer{}
+			server = configserver.NewServer([]configserver.Backend{staticBackend{
+				{Name: "billing", Origin: "test:billing", Data: map[string]interface{}{
+					"db":    map[string]interface{}{"host": "db", "admin_password": "s3cret"},
+					"queue": "billing",
+				}},
+			}}, log.New(logs, "", 0))
+			Expect(server.Load()).To(Succeed())
+			server.Token = "ignored"
+			server.Policy = policy
+			policy.Identities[0].Token = "migrate-token"
+			policy.Identities[1].Token = "web-token"
+			server.Services, _ = configserver.ParseServices(vcapServices)
+		})
+
+		It("authenticates identities by token", func() {
+			Expect(get("/config/billing", "ignored").Code).To(Equal(http.StatusUnauthorized))
+			Expect(get("/config/billing", "web-token").Code).To(Equal(http.StatusOK))
+		})
+
+		It("serves each identity the keys it may read", func() {
+			Expect(get("/config/billing", "web-token").Body.String()).To(MatchJSON(`{"db":{"host":"db"},"queue":"billing"}`))
+			Expect(get("/config/billing", "migrate-token").Body.String()).To(MatchJSON(`{"db":{"host":"db","admin_password":"s3cret"}}`))
+			Expect(get("/config/billing?path=queue", "migrate-token").Code).To(Equal(http.StatusNotFound))
+			Expect(get("/billing-default.properties", "web-token").Body.String()).To(Equal("db.host: db\nqueue: billing\n"))
+		})
+
+		It("filters bound services", func() {
+			Expect(get("/services/postgres", "migrate-token").Body.String()).To(MatchJSON(`{
+				"credentials": {"uri": "postgres://user:pass@db/app", "hosts": ["db1", "db2"], "port": 5432}
+			}`))
+			Expect(get("/services/cache", "migrate-token").Body.String()).To(MatchJSON(`{}`))
+		})
+
+		It("logs denials", func() {
+			get("/config/billing", "web-token")
+			Expect(logs.String()).To(ContainSubstring(`Denied web access to 1 keys of "billing": db.admin_password`))
+		})
+	})
+})
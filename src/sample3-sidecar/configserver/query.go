@@ -0,0 +1,81 @@
+package configserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+)
+
+// queryError is the body of responses to path queries that cannot be
+// answered, so clients can tell a missing path from a missing document.
+type queryError struct {
+	Error   string `json:"error"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// query serves the fragment of a resolved document selected by a gjson
+// path, such as database.primary.host or servers.#[region=="eu"].host.
+// Fragments that are not objects can only be served as JSON.
+func (s *Server) query(res http.ResponseWriter, name, path string, resolved *resolvedDocument, format *Format, ifNoneMatch string) {
+	result := gjson.GetBytes(resolved.json, path)
+	if !result.Exists() {
+		s.Log.Printf("Received a request for missing path %q in config %q.", path, name)
+		writeQueryError(res, http.StatusNotFound, queryError{
+			Error:   "path_not_found",
+			Name:    name,
+			Path:    path,
+			Message: "no value matches the path",
+		})
+		return
+	}
+
+	fragment, isObject := result.Value().(map[string]interface{})
+	if format != Formats[0] && !isObject {
+		writeQueryError(res, http.StatusNotAcceptable, queryError{
+			Error:   "not_an_object",
+			Name:    name,
+			Path:    path,
+			Message: "only objects can be served as " + format.Name + ", request application/json",
+		})
+		return
+	}
+
+	// The ETag depends on the fragment only, so clients polling a single
+	// value are not sent it again when other keys change.
+	raw := []byte(result.Raw)
+	if len(raw) == 0 {
+		// Computed results, such as the length of an array, have no raw
+		// JSON of their own.
+		raw, _ = json.Marshal(result.Value())
+	}
+	etag := `"` + jsonVersion(raw) + `"`
+	if format != Formats[0] {
+		etag = `"` + jsonVersion(raw) + "-" + format.Name + `"`
+	}
+	res.Header().Set("ETag", etag)
+	if etagMatches(ifNoneMatch, etag) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	s.Log.Printf("Received a request for path %q in config %q as %s.", path, name, format.Name)
+	res.Header().Set("Content-Type", format.ContentType())
+	if format == Formats[0] {
+		res.Write(append(raw, '\n'))
+		return
+	}
+	if err := format.Write(res, fragment); err != nil {
+		s.Log.Printf("Unable to write path %q in config %q as %s: %s", path, name, format.Name, err)
+	}
+}
+
+func writeQueryError(res http.ResponseWriter, code int, body queryError) {
+	js, _ := json.Marshal(body)
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(code)
+	res.Write(append(js, '\n'))
+}
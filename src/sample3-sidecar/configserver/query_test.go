@@ -0,0 +1,85 @@
+package configserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Path queries", func() {
+	var server *configserver.Server
+
+	BeforeEach(func() {
+		server = newServer(&configserver.Source{Name: "billing", Origin: "test:billing", Data: map[string]interface{}{
+			"database": map[string]interface{}{
+				"primary": map[string]interface{}{"host": "db1", "port": 5432.0},
+			},
+			"servers": []interface{}{
+				map[string]interface{}{"region": "eu", "host": "eu.example.com"},
+				map[string]interface{}{"region": "us", "host": "us.example.com"},
+			},
+		}})
+	})
+
+	query := func(path, gjsonPath string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path+"?path="+url.QueryEscape(gjsonPath), nil))
+		return res
+	}
+
+	It("serves the fragment at the path", func() {
+		res := query("/config/billing", "database.primary.host")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(res.Body.String()).To(Equal("\"db1\"\n"))
+
+		Expect(query("/config/billing", "database.primary").Body.String()).To(MatchJSON(`{"host":"db1","port":5432}`))
+	})
+
+	It("supports array queries", func() {
+		Expect(query("/config/billing", `servers.#[region=="us"].host`).Body.String()).To(MatchJSON(`"us.example.com"`))
+		Expect(query("/config/billing", `servers.#[host%"*.example.com"]#.region`).Body.String()).To(MatchJSON(`["eu","us"]`))
+		Expect(query("/config/billing", "servers.#").Body.String()).To(MatchJSON(`2`))
+	})
+
+	It("returns 404 with a JSON body for missing paths", func() {
+		res := query("/config/billing", "database.replica.host")
+		Expect(res.Code).To(Equal(http.StatusNotFound))
+		Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(res.Body.String()).To(MatchJSON(`{
+			"error": "path_not_found",
+			"name": "billing",
+			"path": "database.replica.host",
+			"message": "no value matches the path"
+		}`))
+
+		Expect(query("/config/billing", `servers.#[region=="ap"].host`).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("serves object fragments in other formats", func() {
+		res := query("/config/billing.yml", "database.primary")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(MatchYAML("host: db1\nport: 5432\n"))
+
+		res = query("/config/billing.env", "database.primary.host")
+		Expect(res.Code).To(Equal(http.StatusNotAcceptable))
+		Expect(res.Body.String()).To(ContainSubstring(`"error":"not_an_object"`))
+	})
+
+	It("tags fragments with their own ETag", func() {
+		res := query("/config/billing", "database.primary.host")
+		etag := res.Header().Get("ETag")
+		Expect(etag).NotTo(BeEmpty())
+		Expect(etag).NotTo(Equal(query("/config/billing", "database.primary.port").Header().Get("ETag")))
+
+		req := httptest.NewRequest("GET", "/config/billing?path=database.primary.host", nil)
+		req.Header.Set("If-None-Match", etag)
+		res = httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, req)
+		Expect(res.Code).To(Equal(http.StatusNotModified))
+	})
+})
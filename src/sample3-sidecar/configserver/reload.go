@@ -0,0 +1,214 @@
+package configserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// Refresh reloads every backend and returns, for each document name, the
+// flattened keys that were added, removed or changed. If a backend fails
+// the previous documents keep being served and no keys are returned.
+func (s *Server) Refresh() (map[string][]string, error) {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	s.mu.RLock()
+	before := s.sources
+	s.mu.RUnlock()
+
+	if err := s.Load(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	after := s.sources
+	s.mu.RUnlock()
+	return ChangedKeys(before, after), nil
+}
+
+// ChangedKeys compares two sets of sources and returns, for each document
+// name, the sorted flattened keys whose values differ. Names without
+// changes are left out.
+func ChangedKeys(before, after []*Source) map[string][]string {
+	previous, current := flattenByName(before), flattenByName(after)
+	for name := range current {
+		if _, ok := previous[name]; !ok {
+			previous[name] = map[string]interface{}{}
+		}
+	}
+
+	changed := map[string][]string{}
+	for name, oldFlat := range previous {
+		newFlat := current[name]
+		var keys []string
+		for key, value := range oldFlat {
+			if newValue, ok := newFlat[key]; !ok || !reflect.DeepEqual(value, newValue) {
+				keys = append(keys, key)
+			}
+		}
+		for key := range newFlat {
+			if _, ok := oldFlat[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			changed[name] = keys
+		}
+	}
+	return changed
+}
+
+// flattenByName merges the sources sharing a name and flattens the result.
+func flattenByName(sources []*Source) map[string]map[string]interface{} {
+	byName := map[string][]*Source{}
+	for _, source := range sources {
+		byName[source.Name] = append(byName[source.Name], source)
+	}
+
+	flat := map[string]map[string]interface{}{}
+	for name, named := range byName {
+		flat[name] = Flatten(MergeSources(named))
+	}
+	return flat
+}
+
+// refresh serves POST /refresh, reloading every backend and returning the
+// changed keys of each document. The keys are not filtered by the access
+// policy, so only admins may reload.
+func (s *Server) refresh(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.Header().Set("Allow", http.MethodPost)
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.admin(req) {
+		s.Log.Printf("Rejected a request to reload config from a caller that is not an admin.")
+		http.Error(res, "reloading config requires an admin identity", http.StatusForbidden)
+		return
+	}
+
+	changed, err := s.Refresh()
+	if err != nil {
+		s.Log.Printf("Unable to reload config, still serving the previous documents: %s", err)
+		http.Error(res, "unable to reload config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.logChanges(changed)
+
+	js, err := json.Marshal(map[string]interface{}{"changed": changed})
+	if err != nil {
+		http.Error(res, "unable to marshal changed keys", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Write(js)
+}
+
+// Watch reloads the server whenever a file matched by a FileBackend, by
+// the ops file patterns or in the schema directory is written, created,
+// removed or renamed. Changes are collected for delay before reloading, so
+// that editors writing a file in several steps cause a single reload.
+// Watch returns when done is closed.
+func (s *Server) Watch(done <-chan struct{}, delay time.Duration) error {
+	var patterns []string
+	for _, backend := range s.backends {
+		if files, ok := backend.(*FileBackend); ok {
+			patterns = append(patterns, files.Patterns...)
+		}
+	}
+	if s.Ops != nil {
+		patterns = append(patterns, s.Ops.Patterns...)
+	}
+	if s.Schemas != nil {
+		patterns = append(patterns, s.Schemas.patterns()...)
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for _, dir := range watchDirs(patterns) {
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+		s.Log.Printf("Watching %s for config changes.", dir)
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-done:
+			return nil
+		case event := <-watcher.Events:
+			if matchesAny(patterns, event.Name) {
+				reload = time.After(delay)
+			}
+		case err := <-watcher.Errors:
+			s.Log.Printf("Error watching config files: %s", err)
+		case <-reload:
+			reload = nil
+			changed, err := s.Refresh()
+			if err != nil {
+				s.Log.Printf("Unable to reload config, still serving the previous documents: %s", err)
+				continue
+			}
+			s.logChanges(changed)
+		}
+	}
+}
+
+func (s *Server) logChanges(changed map[string][]string) {
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		s.Log.Println("Reloaded config, nothing changed.")
+	}
+	for _, name := range names {
+		s.Log.Printf("Reloaded config %q, changed keys: %v", name, changed[name])
+	}
+}
+
+// watchDirs returns the existing directories holding the files matched by
+// patterns. Directories are watched rather than files so that files
+// replaced by a rename, as most editors do, keep being watched.
+func watchDirs(patterns []string) []string {
+	seen := map[string]bool{}
+	var dirs []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Dir(pattern))
+		for _, dir := range matches {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() || seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func matchesAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package configserver_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reload", func() {
+	var (
+		dir    string
+		server *configserver.Server
+	)
+
+	writeFile := func(name, contents string) {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path+".tmp", []byte(contents), 0644)).To(Succeed())
+		Expect(os.Rename(path+".tmp", path)).To(Succeed())
+	}
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, httptest.NewRequest(method, path, nil))
+		return res
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "configserver")
+		Expect(err).NotTo(HaveOccurred())
+
+		writeFile("application.yml", "db:\n  url: postgres://localhost\n  pool: 5\n")
+		writeFile("billing.yml", "currency: EUR\n")
+		backend := &configserver.FileBackend{Patterns: []string{filepath.Join(dir, "*.yml")}}
+		server = configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+		Expect(server.Load()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("ChangedKeys", func() {
+		It("lists added, removed and changed keys by document", func() {
+			before := []*configserver.Source{
+				{Name: "application", Data: map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": 2}}},
+				{Name: "gone", Data: map[string]interface{}{"x": 1}},
+			}
+			after := []*configserver.Source{
+				{Name: "application", Data: map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": 3, "d": 4}}},
+				{Name: "new", Data: map[string]interface{}{"y": 1}},
+			}
+
+			Expect(configserver.ChangedKeys(before, after)).To(Equal(map[string][]string{
+				"application": {"b.c", "b.d"},
+				"gone":        {"x"},
+				"new":         {"y"},
+			}))
+		})
+	})
+
+	Describe("POST /refresh", func() {
+		It("reloads the files and returns the changed keys", func() {
+			writeFile("application.yml", "db:\n  url: postgres://prod\n  pool: 5\n")
+
+			res := request("POST", "/refresh")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(MatchJSON(`{"changed": {"application": ["db.url"]}}`))
+			Expect(request("GET", "/config/").Body.String()).To(ContainSubstring("postgres://prod"))
+		})
+
+		It("keeps serving the last good documents when a file is broken", func() {
+			writeFile("billing.yml", "currency: [EUR\n")
+
+			res := request("POST", "/refresh")
+			Expect(res.Code).To(Equal(http.StatusInternalServerError))
+			Expect(res.Body.String()).To(ContainSubstring("unable to parse config source"))
+			Expect(request("GET", "/config/billing").Body.String()).To(ContainSubstring("EUR"))
+		})
+
+		It("only lets admins reload", func() {
+			policy, err := configserver.ParsePolicy([]byte(accessPolicy + "  ops:\n    allow: ['*']\n    admin: true\n"))
+			Expect(err).NotTo(HaveOccurred())
+			for _, identity := range policy.Identities {
+				identity.Token = identity.Name + "-token"
+			}
+			server.Policy = policy
+			writeFile("application.yml", "db:\n  url: postgres://prod\n  pool: 5\n")
+
+			refresh := func(token string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("POST", "/refresh", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				res := httptest.NewRecorder()
+				server.Handler().ServeHTTP(res, req)
+				return res
+			}
+			res := refresh("migrate-token")
+			Expect(res.Code).To(Equal(http.StatusForbidden))
+			Expect(res.Body.String()).NotTo(ContainSubstring("db.url"))
+			Expect(refresh("ops-token").Body.String()).To(MatchJSON(`{"changed": {"application": ["db.url"]}}`))
+		})
+
+		It("only accepts POST", func() {
+			res := request("GET", "/refresh")
+			Expect(res.Code).To(Equal(http.StatusMethodNotAllowed))
+			Expect(res.Header().Get("Allow")).To(Equal("POST"))
+		})
+	})
+
+	Describe("Watch", func() {
+		var done chan struct{}
+
+		BeforeEach(func() {
+			done = make(chan struct{})
+			go server.Watch(done, 10*time.Millisecond)
+		})
+
+		AfterEach(func() {
+			close(done)
+		})
+
+		currency := func() string {
+			var document map[string]interface{}
+			json.Unmarshal(request("GET", "/config/billing").Body.Bytes(), &document)
+			currency, _ := document["currency"].(string)
+			return currency
+		}
+
+		It("reloads when a watched file changes", func() {
+			Eventually(func() string {
+				writeFile("billing.yml", "currency: USD\n")
+				return currency()
+			}).Should(Equal("USD"))
+		})
+
+		It("keeps the last good documents when a changed file is broken", func() {
+			Eventually(func() string {
+				writeFile("billing.yml", "currency: USD\n")
+				return currency()
+			}).Should(Equal("USD"))
+
+			writeFile("billing.yml", "currency: [GBP\n")
+			Consistently(currency, 100*time.Millisecond).Should(Equal("USD"))
+		})
+	})
+})
@@ -0,0 +1,52 @@
+package configserver
+
+// DefaultName is the name of the document shared by every app. It is layered
+// underneath each named document.
+const DefaultName = "application"
+
+// Resolve returns the sources making up the document for name and profiles,
+// ordered from lowest to highest precedence:
+//
+//	application.yml, <name>.yml, application-<profile>.yml, <name>-<profile>.yml
+//
+// Later profiles take precedence over earlier ones. The second return value
+// is false when no source belongs to name itself, in which case the document
+// does not exist even if shared application sources do.
+func Resolve(sources []*Source, name string, profiles []string) ([]*Source, bool) {
+	type layer struct {
+		key string
+		own bool
+	}
+
+	layers := []layer{{DefaultName, name == DefaultName}}
+	if name != DefaultName {
+		layers = append(layers, layer{name, true})
+	}
+	for _, profile := range profiles {
+		layers = append(layers, layer{DefaultName + "-" + profile, name == DefaultName})
+		if name != DefaultName {
+			layers = append(layers, layer{name + "-" + profile, true})
+		}
+	}
+
+	var resolved []*Source
+	found := false
+	for _, l := range layers {
+		for _, source := range sources {
+			if source.Name == l.key {
+				resolved = append(resolved, source)
+				found = found || l.own
+			}
+		}
+	}
+	return resolved, found
+}
+
+// MergeSources merges the data of the given sources in order.
+func MergeSources(sources []*Source) map[string]interface{} {
+	documents := make([]map[string]interface{}, len(sources))
+	for i, source := range sources {
+		documents[i] = source.Data
+	}
+	return Merge(documents...)
+}
@@ -0,0 +1,67 @@
+package configserver_test
+
+import (
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolve", func() {
+	var sources []*configserver.Source
+
+	source := func(name string) *configserver.Source {
+		return &configserver.Source{Name: name, Origin: "file:" + name + ".yml", Data: map[string]interface{}{"from": name}}
+	}
+
+	names := func(sources []*configserver.Source) []string {
+		var result []string
+		for _, s := range sources {
+			result = append(result, s.Name)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		sources = []*configserver.Source{
+			source("billing-prod"),
+			source("application-prod"),
+			source("billing"),
+			source("application"),
+			source("orders-prod"),
+		}
+	})
+
+	It("layers the application document underneath the named document", func() {
+		resolved, found := configserver.Resolve(sources, "billing", nil)
+		Expect(found).To(BeTrue())
+		Expect(names(resolved)).To(Equal([]string{"application", "billing"}))
+	})
+
+	It("layers profile specific documents on top", func() {
+		resolved, found := configserver.Resolve(sources, "billing", []string{"prod"})
+		Expect(found).To(BeTrue())
+		Expect(names(resolved)).To(Equal([]string{"application", "billing", "application-prod", "billing-prod"}))
+		Expect(configserver.MergeSources(resolved)).To(HaveKeyWithValue("from", "billing-prod"))
+	})
+
+	It("finds names that only have a profile specific document", func() {
+		resolved, found := configserver.Resolve(sources, "orders", []string{"prod"})
+		Expect(found).To(BeTrue())
+		Expect(names(resolved)).To(Equal([]string{"application", "application-prod", "orders-prod"}))
+	})
+
+	It("does not find names that only match the application documents", func() {
+		_, found := configserver.Resolve(sources, "unknown", []string{"prod"})
+		Expect(found).To(BeFalse())
+
+		_, found = configserver.Resolve(sources, "orders", nil)
+		Expect(found).To(BeFalse())
+	})
+
+	It("resolves the application document itself", func() {
+		resolved, found := configserver.Resolve(sources, "application", []string{"prod"})
+		Expect(found).To(BeTrue())
+		Expect(names(resolved)).To(Equal([]string{"application", "application-prod"}))
+	})
+})
@@ -0,0 +1,493 @@
+package configserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultSchemaDir holds the JSON Schemas of the documents when
+// $CONFIG_SERVER_SCHEMAS is not set.
+const DefaultSchemaDir = "config/schemas"
+
+// Schemas validates resolved documents against the JSON Schema named after
+// each document, such as billing.json, in Dir. Schemas may also be written
+// in YAML. They are read on every load, so reloads pick up their changes.
+type Schemas struct {
+	Dir string
+}
+
+// Violation is a value of a document that does not match its schema. Key
+// is the flattened key of the value, empty for the whole document.
+type Violation struct {
+	Document string `json:"document"`
+	Key      string `json:"key"`
+	Message  string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Key == "" {
+		return fmt.Sprintf("%s: %s", v.Document, v.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", v.Document, v.Key, v.Message)
+}
+
+// SchemaError fails a load whose documents violate their schemas.
+type SchemaError struct {
+	Violations []Violation
+}
+
+func (e *SchemaError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.String()
+	}
+	return fmt.Sprintf("%d schema violations: %s", len(e.Violations), strings.Join(messages, "; "))
+}
+
+func (s *Schemas) patterns() []string {
+	return []string{filepath.Join(s.Dir, "*.json"), filepath.Join(s.Dir, "*.yml"), filepath.Join(s.Dir, "*.yaml")}
+}
+
+// Validate checks every document with a schema, resolved without a profile
+// and with each profile it has a layer for, once decrypted. Without a
+// cipher, {cipher} values are checked as the strings they are, so that
+// documents can be validated where the key is not available. It fails with
+// a *SchemaError listing the violations.
+func (s *Schemas) Validate(sources []*Source, cipher *Cipher) error {
+	var files []string
+	for _, pattern := range s.patterns() {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema directory %s: %s", s.Dir, err)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	var violations []Violation
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read schema: %s", err)
+		}
+		data, err := parseDocument(file, contents)
+		if err != nil {
+			return fmt.Errorf("unable to parse schema %s: %s", file, err)
+		}
+		name, schema := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), NewSchema(data)
+		variants := [][]string{nil}
+		for _, profile := range documentProfiles(sources, name) {
+			variants = append(variants, []string{profile})
+		}
+
+		for _, profiles := range variants {
+			resolved, found := Resolve(sources, name, profiles)
+			if !found {
+				continue
+			}
+			document := strings.Join(append([]string{name}, profiles...), "/")
+			merged := MergeSources(resolved)
+			if cipher != nil {
+				if merged, err = cipher.DecryptDocument(merged); err != nil {
+					return fmt.Errorf("unable to validate config %q: %s", document, err)
+				}
+			}
+			for _, violation := range schema.Validate(merged) {
+				violation.Document = document
+				violations = append(violations, violation)
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
+	return nil
+}
+
+// documentProfiles returns the sorted profiles that have a layer of their
+// own for the document name.
+func documentProfiles(sources []*Source, name string) []string {
+	seen := map[string]bool{}
+	var profiles []string
+	for _, source := range sources {
+		profile := strings.TrimPrefix(source.Name, name+"-")
+		if profile != source.Name && profile != "" && !seen[profile] {
+			seen[profile] = true
+			profiles = append(profiles, profile)
+		}
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// Schema is a JSON Schema. It implements the validation keywords of draft 7
+// that describe the shape of a config document:
+//
+//	type, enum, const, $ref to the same schema,
+//	properties, required, additionalProperties, patternProperties,
+//	items, minItems, maxItems, uniqueItems,
+//	minLength, maxLength, pattern,
+//	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf,
+//	allOf, anyOf, oneOf, not
+//
+// Other keywords, such as format, are ignored, as the specification allows.
+type Schema struct {
+	root map[string]interface{}
+}
+
+// NewSchema returns the schema held by a parsed JSON or YAML document.
+func NewSchema(root map[string]interface{}) *Schema {
+	return &Schema{root: root}
+}
+
+// Validate returns the violations of document, ordered by key.
+func (s *Schema) Validate(document map[string]interface{}) []Violation {
+	var violations []Violation
+	s.validate(s.root, document, "", &violations, 0)
+	return violations
+}
+
+// maxRefDepth stops schemas whose references loop without consuming the
+// document.
+const maxRefDepth = 64
+
+func (s *Schema) validate(schema interface{}, value interface{}, key string, violations *[]Violation, depth int) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if allowed, ok := schema.(bool); ok {
+		if !allowed {
+			fail("no value is allowed")
+		}
+		return
+	}
+	sc, ok := schema.(map[string]interface{})
+	if !ok {
+		fail("invalid schema: expected an object or a boolean, found %s", jsonType(schema))
+		return
+	}
+
+	if ref, ok := sc["$ref"].(string); ok {
+		target, err := s.resolveRef(ref)
+		switch {
+		case err != nil:
+			fail("invalid schema: %s", err)
+		case depth >= maxRefDepth:
+			fail("invalid schema: $ref %s nests too deeply", ref)
+		default:
+			s.validate(target, value, key, violations, depth+1)
+		}
+		// In draft 7, keywords beside $ref are ignored.
+		return
+	}
+
+	if types, ok := sc["type"]; ok && !matchesType(types, value) {
+		fail("expected %s, found %s", describeTypes(types), jsonType(value))
+		return
+	}
+	if enum, ok := sc["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			found = found || jsonEqual(candidate, value)
+		}
+		if !found {
+			fail("must be one of %s", describeValues(enum))
+		}
+	}
+	if constant, ok := sc["const"]; ok && !jsonEqual(constant, value) {
+		fail("must be %s", describeValues([]interface{}{constant}))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(sc, v, key, violations, depth)
+	case []interface{}:
+		s.validateArray(sc, v, key, violations, depth)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if min, ok := number(sc["minLength"]); ok && float64(length) < min {
+			fail("must be at least %v characters long", min)
+		}
+		if max, ok := number(sc["maxLength"]); ok && float64(length) > max {
+			fail("must be at most %v characters long", max)
+		}
+		if pattern, ok := sc["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				fail("invalid schema: pattern %q: %s", pattern, err)
+			} else if !re.MatchString(v) {
+				fail("must match %q", pattern)
+			}
+		}
+	default:
+		if n, ok := number(v); ok {
+			if min, ok := number(sc["minimum"]); ok && n < min {
+				fail("must be at least %v", min)
+			}
+			if max, ok := number(sc["maximum"]); ok && n > max {
+				fail("must be at most %v", max)
+			}
+			if min, ok := number(sc["exclusiveMinimum"]); ok && n <= min {
+				fail("must be greater than %v", min)
+			}
+			if max, ok := number(sc["exclusiveMaximum"]); ok && n >= max {
+				fail("must be less than %v", max)
+			}
+			if divisor, ok := number(sc["multipleOf"]); ok && divisor > 0 {
+				if quotient := n / divisor; quotient != math.Trunc(quotient) {
+					fail("must be a multiple of %v", divisor)
+				}
+			}
+		}
+	}
+
+	if all, ok := sc["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			s.validate(sub, value, key, violations, depth)
+		}
+	}
+	if anyOf, ok := sc["anyOf"].([]interface{}); ok && s.countMatches(anyOf, value, key, depth) == 0 {
+		fail("must match at least one of the anyOf schemas")
+	}
+	if oneOf, ok := sc["oneOf"].([]interface{}); ok {
+		if matches := s.countMatches(oneOf, value, key, depth); matches != 1 {
+			fail("must match exactly one of the oneOf schemas, matches %d", matches)
+		}
+	}
+	if not, ok := sc["not"]; ok && s.countMatches([]interface{}{not}, value, key, depth) == 1 {
+		fail("must not match the not schema")
+	}
+}
+
+func (s *Schema) validateObject(sc map[string]interface{}, object map[string]interface{}, key string, violations *[]Violation, depth int) {
+	childKey := func(name string) string {
+		if key == "" {
+			return name
+		}
+		return key + "." + name
+	}
+
+	if required, ok := sc["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := object[name]; !present {
+					*violations = append(*violations, Violation{Key: key, Message: fmt.Sprintf("missing required key %q", name)})
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	properties, _ := sc["properties"].(map[string]interface{})
+	patternProperties, _ := sc["patternProperties"].(map[string]interface{})
+	for _, name := range names {
+		matched := false
+		if property, ok := properties[name]; ok {
+			matched = true
+			s.validate(property, object[name], childKey(name), violations, depth)
+		}
+		for pattern, property := range patternProperties {
+			if re, err := regexp.Compile(pattern); err == nil && re.MatchString(name) {
+				matched = true
+				s.validate(property, object[name], childKey(name), violations, depth)
+			}
+		}
+		if additional, ok := sc["additionalProperties"]; ok && !matched {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				*violations = append(*violations, Violation{Key: childKey(name), Message: "is not an allowed key"})
+			} else {
+				s.validate(additional, object[name], childKey(name), violations, depth)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateArray(sc map[string]interface{}, array []interface{}, key string, violations *[]Violation, depth int) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	if min, ok := number(sc["minItems"]); ok && float64(len(array)) < min {
+		fail("must have at least %v items", min)
+	}
+	if max, ok := number(sc["maxItems"]); ok && float64(len(array)) > max {
+		fail("must have at most %v items", max)
+	}
+	if unique, _ := sc["uniqueItems"].(bool); unique {
+		for i := range array {
+			for j := 0; j < i; j++ {
+				if jsonEqual(array[i], array[j]) {
+					fail("items %d and %d are equal", j, i)
+				}
+			}
+		}
+	}
+
+	switch items := sc["items"].(type) {
+	case nil:
+	case []interface{}:
+		for i, item := range array {
+			if i < len(items) {
+				s.validate(items[i], item, fmt.Sprintf("%s[%d]", key, i), violations, depth)
+			}
+		}
+	default:
+		for i, item := range array {
+			s.validate(items, item, fmt.Sprintf("%s[%d]", key, i), violations, depth)
+		}
+	}
+}
+
+// countMatches returns how many of schemas value matches.
+func (s *Schema) countMatches(schemas []interface{}, value interface{}, key string, depth int) int {
+	matches := 0
+	for _, sub := range schemas {
+		var violations []Violation
+		s.validate(sub, value, key, &violations, depth)
+		if len(violations) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// resolveRef returns the subschema a $ref JSON pointer, such as
+// #/definitions/port, points to within the schema.
+func (s *Schema) resolveRef(ref string) (interface{}, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("$ref %s: only references within the schema are supported", ref)
+	}
+	var target interface{} = s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		object, ok := target.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref %s not found", ref)
+		}
+		if target, ok = object[token]; !ok {
+			return nil, fmt.Errorf("$ref %s not found", ref)
+		}
+	}
+	return target, nil
+}
+
+func matchesType(types interface{}, value interface{}) bool {
+	actual := jsonType(value)
+	names, ok := types.([]interface{})
+	if !ok {
+		names = []interface{}{types}
+	}
+	for _, name := range names {
+		if name == actual || name == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func describeTypes(types interface{}) string {
+	names, ok := types.([]interface{})
+	if !ok {
+		return fmt.Sprint(types)
+	}
+	described := make([]string, len(names))
+	for i, name := range names {
+		described[i] = fmt.Sprint(name)
+	}
+	return strings.Join(described, " or ")
+}
+
+func describeValues(values []interface{}) string {
+	described := make([]string, len(values))
+	for i, value := range values {
+		js, _ := json.Marshal(value)
+		described[i] = string(js)
+	}
+	return strings.Join(described, ", ")
+}
+
+// jsonType returns the JSON Schema type of a value decoded from JSON or
+// YAML. Whole numbers are integers.
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		if n, ok := number(v); ok {
+			if n == math.Trunc(n) && !math.IsInf(n, 0) {
+				return "integer"
+			}
+			return "number"
+		}
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// number converts the numeric types the JSON and YAML decoders produce.
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// jsonEqual compares values as JSON does, where 1 and 1.0 are equal.
+func jsonEqual(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
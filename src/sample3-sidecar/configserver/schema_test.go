@@ -0,0 +1,159 @@
+package configserver_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const billingSchema = `{
+	"type": "object",
+	"required": ["currency", "db"],
+	"additionalProperties": false,
+	"properties": {
+		"currency": {"enum": ["EUR", "USD"]},
+		"db": {"$ref": "#/definitions/db"},
+		"hosts": {
+			"type": "array",
+			"minItems": 1,
+			"uniqueItems": true,
+			"items": {"type": "string", "pattern": "^[a-z0-9.]+$"}
+		}
+	},
+	"definitions": {
+		"db": {
+			"type": "object",
+			"required": ["port"],
+			"properties": {
+				"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+				"pool": {"type": ["integer", "null"], "exclusiveMinimum": 0}
+			}
+		}
+	}
+}`
+
+func parseJSON(js string) map[string]interface{} {
+	var data map[string]interface{}
+	Expect(json.Unmarshal([]byte(js), &data)).To(Succeed())
+	return data
+}
+
+var _ = Describe("Schema", func() {
+	schema := func() *configserver.Schema {
+		return configserver.NewSchema(parseJSON(billingSchema))
+	}
+
+	It("accepts valid documents", func() {
+		Expect(schema().Validate(parseJSON(`{"currency": "EUR", "db": {"port": 5432, "pool": null}, "hosts": ["db1", "db2"]}`))).To(BeEmpty())
+	})
+
+	It("reports each violation with its key", func() {
+		violations := schema().Validate(parseJSON(`{
+			"curency": "EUR",
+			"db": {"port": "5432", "pool": 0},
+			"hosts": ["db1", "db1", "DB2"]
+		}`))
+		Expect(violations).To(Equal([]configserver.Violation{
+			{Key: "", Message: `missing required key "currency"`},
+			{Key: "curency", Message: "is not an allowed key"},
+			{Key: "db.pool", Message: "must be greater than 0"},
+			{Key: "db.port", Message: "expected integer, found string"},
+			{Key: "hosts", Message: "items 0 and 1 are equal"},
+			{Key: "hosts[2]", Message: `must match "^[a-z0-9.]+$"`},
+		}))
+	})
+
+	It("checks enums, ranges and combinators", func() {
+		Expect(schema().Validate(parseJSON(`{"currency": "GBP", "db": {"port": 70000}}`))).To(Equal([]configserver.Violation{
+			{Key: "currency", Message: `must be one of "EUR", "USD"`},
+			{Key: "db.port", Message: "must be at most 65535"},
+		}))
+
+		oneOf := configserver.NewSchema(parseJSON(`{"properties": {"port": {"oneOf": [{"type": "integer"}, {"type": "string", "pattern": "^[0-9]+$"}]}}}`))
+		Expect(oneOf.Validate(parseJSON(`{"port": "8080"}`))).To(BeEmpty())
+		Expect(oneOf.Validate(parseJSON(`{"port": 8080.5}`))).To(Equal([]configserver.Violation{
+			{Key: "port", Message: "must match exactly one of the oneOf schemas, matches 0"},
+		}))
+	})
+
+	It("reports invalid references", func() {
+		invalid := configserver.NewSchema(parseJSON(`{"$ref": "other.json#/definitions/db"}`))
+		Expect(invalid.Validate(map[string]interface{}{})).To(HaveLen(1))
+	})
+
+	Describe("Schemas", func() {
+		var (
+			dir     string
+			backend *flakyBackend
+			server  *configserver.Server
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "configserver")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "billing.json"), []byte(billingSchema), 0644)).To(Succeed())
+
+			backend = &flakyBackend{sources: []*configserver.Source{
+				{Name: "billing", Origin: "flaky:billing", Data: parseJSON(`{"currency": "EUR", "db": {"port": 5432}}`)},
+			}}
+			server = configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+			server.Schemas = &configserver.Schemas{Dir: dir}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("loads valid documents", func() {
+			Expect(server.Load()).To(Succeed())
+			Expect(server.Status().Violations).To(BeEmpty())
+		})
+
+		It("refuses to load invalid documents, and reports their violations", func() {
+			Expect(server.Load()).To(Succeed())
+
+			backend.sources = append(backend.sources, &configserver.Source{
+				Name: "billing-prod", Origin: "flaky:billing-prod", Data: parseJSON(`{"db": {"port": "5432"}}`),
+			})
+			err := server.Load()
+			Expect(err).To(MatchError(`1 schema violations: billing/prod: db.port: expected integer, found string`))
+			Expect(server.Status().Violations).To(Equal([]configserver.Violation{
+				{Document: "billing/prod", Key: "db.port", Message: "expected integer, found string"},
+			}))
+			Expect(server.Sources()).To(HaveLen(1))
+
+			backend.sources = backend.sources[:1]
+			Expect(server.Load()).To(Succeed())
+			Expect(server.Status().Violations).To(BeEmpty())
+		})
+
+		It("validates encrypted values as strings without a key", func() {
+			cipher, err := configserver.NewCipher(testKey)
+			Expect(err).NotTo(HaveOccurred())
+			encrypted, err := cipher.Encrypt("s3cret")
+			Expect(err).NotTo(HaveOccurred())
+			backend.sources[0].Data = parseJSON(`{"currency": "EUR", "db": {"port": 5432, "password": "` + encrypted + `"}}`)
+			Expect(server.Load()).To(Succeed())
+
+			server.Cipher, err = configserver.NewCipher("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.Load()).To(MatchError(HavePrefix(`unable to validate config "billing": db.password: `)))
+		})
+
+		It("reads schemas on every load", func() {
+			Expect(server.Load()).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "billing.json"), []byte(`{"required": ["region"]}`), 0644)).To(Succeed())
+			Expect(server.Load()).To(MatchError(ContainSubstring(`billing: missing required key "region"`)))
+
+			Expect(ioutil.WriteFile(filepath.Join(dir, "billing.json"), []byte(`{`), 0644)).To(Succeed())
+			Expect(server.Load()).To(MatchError(ContainSubstring("unable to parse schema")))
+		})
+	})
+})
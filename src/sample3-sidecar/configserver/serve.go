@@ -0,0 +1,57 @@
+package configserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Serve serves handler on listener until a signal is received. Cloud Foundry
+// signals the app and its sidecars at the same time, so the server keeps
+// accepting requests for drainWindow to let the app fetch config while it
+// shuts down. It then stops accepting connections and waits up to
+// shutdownTimeout for in-flight requests. A second signal ends the drain
+// window early.
+//
+// Serve returns nil after a clean shutdown and an error if the server failed
+// or in-flight requests had to be abandoned. The onShutdown functions are
+// called when shutdown starts, to end long-lived requests.
+func Serve(listener net.Listener, handler http.Handler, signals <-chan os.Signal, drainWindow, shutdownTimeout time.Duration, logger *log.Logger, onShutdown ...func()) error {
+	server := &http.Server{Handler: handler}
+	for _, f := range onShutdown {
+		server.RegisterOnShutdown(f)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		logger.Printf("Received %s, serving for another %s before shutting down.", sig, drainWindow)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		logger.Printf("Received %s, shutting down now.", sig)
+	case <-time.After(drainWindow):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("in-flight requests did not complete within %s", shutdownTimeout)
+	}
+
+	logger.Println("Shut down cleanly.")
+	return nil
+}
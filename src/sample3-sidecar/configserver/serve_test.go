@@ -0,0 +1,118 @@
+package configserver_test
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sample3-sidecar/configserver"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Serve", func() {
+	var (
+		listener net.Listener
+		signals  chan os.Signal
+		release  chan struct{}
+		done     chan error
+		url      string
+	)
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		url = "http://" + listener.Addr().String()
+
+		signals = make(chan os.Signal, 2)
+		release = make(chan struct{})
+		done = make(chan error, 1)
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte("ok"))
+	})
+
+	serve := func(drainWindow, shutdownTimeout time.Duration) {
+		go func() {
+			done <- configserver.Serve(listener, handler, signals, drainWindow, shutdownTimeout, log.New(GinkgoWriter, "", 0))
+		}()
+	}
+
+	get := func(path string) (string, error) {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		res, err := client.Get(url + path)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	It("keeps serving during the drain window and completes in-flight requests", func() {
+		serve(200*time.Millisecond, time.Second)
+
+		slow := make(chan string, 1)
+		go func() {
+			body, _ := get("/slow")
+			slow <- body
+		}()
+		Eventually(func() error { _, err := get("/"); return err }).Should(Succeed())
+
+		signals <- syscall.SIGTERM
+		Expect(get("/")).To(Equal("ok"))
+		Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+
+		close(release)
+		Eventually(slow).Should(Receive(Equal("ok")))
+		Eventually(done).Should(Receive(BeNil()))
+
+		_, err := get("/")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("ends the drain window on a second signal", func() {
+		serve(time.Minute, time.Second)
+		Eventually(func() error { _, err := get("/"); return err }).Should(Succeed())
+
+		signals <- syscall.SIGTERM
+		Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+		signals <- os.Interrupt
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("calls the shutdown hooks so long-lived requests can end", func() {
+		hooked := make(chan struct{})
+		go func() {
+			done <- configserver.Serve(listener, handler, signals, 0, time.Second, log.New(GinkgoWriter, "", 0), func() { close(hooked) })
+		}()
+		Eventually(func() error { _, err := get("/"); return err }).Should(Succeed())
+
+		signals <- syscall.SIGTERM
+		Eventually(hooked).Should(BeClosed())
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("fails when in-flight requests outlive the shutdown timeout", func() {
+		defer close(release)
+		serve(0, 100*time.Millisecond)
+
+		go get("/slow")
+		Eventually(func() error { _, err := get("/"); return err }).Should(Succeed())
+		time.Sleep(50 * time.Millisecond)
+
+		signals <- syscall.SIGTERM
+		var err error
+		Eventually(done).Should(Receive(&err))
+		Expect(err).To(MatchError("in-flight requests did not complete within 100ms"))
+	})
+})
@@ -0,0 +1,556 @@
+package configserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves configuration documents to the app over HTTP.
+type Server struct {
+	Log *log.Logger
+	// Cipher decrypts {cipher} values as documents are served. When nil,
+	// serving a document with an encrypted value fails.
+	Cipher *Cipher
+	// Token is the shared secret requests must present as a bearer token.
+	// When empty, requests are not authenticated.
+	Token string
+	// Heartbeat is how often idle watch streams send a heartbeat. When zero,
+	// DefaultHeartbeat is used.
+	Heartbeat time.Duration
+	// Services are the bound service instances served under /services/.
+	Services []ServiceInstance
+	// Ops patches the loaded documents before they are interpolated.
+	Ops *OpsFiles
+	// Policy restricts the keys each caller may read. When set, callers
+	// authenticate with the token of an identity in the policy instead of
+	// Token.
+	Policy *Policy
+	// Interpolator replaces ((variables)) in every loaded document. When
+	// nil, placeholders are served as they are.
+	Interpolator *Interpolator
+	// Schemas validates the loaded documents. A load whose documents
+	// violate their schemas fails.
+	Schemas *Schemas
+	// HistorySize is how many versions of each document are kept. When
+	// zero, DefaultHistorySize is used.
+	HistorySize int
+	// Cache persists the documents of every successful Load.
+	Cache *Cache
+	// ServeStale serves the cached documents when the first Load fails
+	// because a remote backend is down, instead of failing it. While the
+	// documents are stale, the backends are reloaded in the background until
+	// they recover.
+	ServeStale bool
+	// RetryInterval is how long to wait before the first background
+	// reload, doubling after each failure up to MaxRetryInterval. When
+	// zero, DefaultRetryInterval is used.
+	RetryInterval time.Duration
+	// CheckInterval is how long the results of backend checks are reused
+	// by Status and /readyz. When zero, DefaultCheckInterval is used.
+	CheckInterval time.Duration
+
+	backends []Backend
+	metrics  *metrics
+	// reloading serializes Refresh, so that each call reports the changes
+	// made by its own reload.
+	reloading sync.Mutex
+	// closing is closed by CloseStreams to end the watch streams.
+	closing      chan struct{}
+	closeStreams sync.Once
+
+	mu       sync.RWMutex
+	sources  []*Source
+	loadedAt time.Time
+	// generation counts the times the sources were replaced, so that
+	// documents resolved from replaced sources are neither cached nor
+	// recorded.
+	generation uint64
+	loadErr    error
+	lastLoadAt time.Time
+	// retrying is set while the backends are reloaded in the background.
+	retrying bool
+	// checks are the results of the last backend checks, made at
+	// checkedAt. checkMu is held while the backends are checked.
+	checkMu   sync.Mutex
+	checks    []BackendStatus
+	checkedAt time.Time
+	// violations are the schema violations that failed the last Load.
+	violations []Violation
+	// cache holds the resolved and decrypted documents served since the
+	// last successful Load, keyed by name and profiles.
+	cache map[string]*resolvedDocument
+	// labels holds the sources loaded at each label requested since the
+	// last successful Load, keyed by the revisions the label selects.
+	labels map[string][]*Source
+	// versions holds the last versions served of each document, oldest
+	// first, and pins the versions documents are frozen to. Both are keyed
+	// like cache.
+	versions map[string][]*documentVersion
+	pins     map[string]*documentVersion
+	// historyChanged is set when versions or pins change, until they are
+	// saved next to the cache. saveMu orders the saves, and restore reads
+	// them back before the first Load.
+	historyChanged bool
+	saveMu         sync.Mutex
+	restore        sync.Once
+	// updated is closed and replaced on every successful Load, and when a
+	// document is pinned or unpinned, waking the watch streams.
+	updated chan struct{}
+}
+
+// DefaultRetryInterval and MaxRetryInterval bound the delays between the
+// background reloads of stale documents.
+const (
+	DefaultRetryInterval = time.Second
+	MaxRetryInterval     = time.Minute
+)
+
+// NewServer returns a server for the documents held by the given backends.
+// Load must be called before the server is used.
+func NewServer(backends []Backend, logger *log.Logger) *Server {
+	return &Server{
+		Log:      logger,
+		backends: backends,
+		metrics:  newMetrics(backends),
+		closing:  make(chan struct{}),
+		updated:  make(chan struct{}),
+		versions: map[string][]*documentVersion{},
+		pins:     map[string]*documentVersion{},
+	}
+}
+
+// Load loads every backend, interpolates ((variables)), validates the
+// documents against their schemas and replaces the served documents. If
+// any backend, variable or schema fails the previously loaded
+// documents are kept, and the server reports itself as not ready until a
+// later Load succeeds. When the first Load fails because a RemoteBackend
+// failed and ServeStale is set, the cached documents are served instead and
+// Load only fails if there are none. Any other failure, such as a malformed
+// file or a schema violation, is a broken deploy that the cache must not
+// hide. With ServeStale, a failed Load is retried in the background.
+func (s *Server) Load() error {
+	s.restore.Do(s.restoreHistory)
+	sources, err := s.loadSources(nil)
+	s.metrics.observeReload(err)
+	var violations []Violation
+	if schemaErr, ok := err.(*SchemaError); ok {
+		violations = schemaErr.Violations
+		for _, violation := range violations {
+			s.Log.Printf("Schema violation in %s", violation)
+		}
+	}
+
+	loadedAt := time.Now()
+	s.mu.Lock()
+	s.lastLoadAt = loadedAt
+	s.loadErr = err
+	s.violations = violations
+	if err == nil {
+		s.replaceSources(sources, loadedAt)
+	}
+	loaded := !s.loadedAt.IsZero()
+	s.mu.Unlock()
+
+	if err != nil {
+		if backendErr, ok := err.(*BackendError); ok && backendErr.Remote && !loaded && s.ServeStale && s.Cache != nil {
+			err = s.loadCache(err)
+		}
+		if s.ServeStale && s.Stale() {
+			s.retryLoads()
+		}
+		return err
+	}
+
+	for _, source := range sources {
+		s.Log.Printf("Loaded config %q from %s", source.Name, source.Origin)
+	}
+	s.recordHistory()
+	if s.Cache != nil {
+		if err := s.Cache.Save(sources, loadedAt); err != nil {
+			s.Log.Printf("Unable to save the config cache %s: %s", s.Cache.Path, err)
+		}
+	}
+	return nil
+}
+
+// loadSources loads the backends, the labeled ones in labels at their
+// label, applies the ops files, interpolates variables and validates the
+// documents.
+func (s *Server) loadSources(labels map[Backend]string) ([]*Source, error) {
+	sources, err := loadBackends(s.backends, labels)
+	if err == nil && s.Ops != nil {
+		sources, err = s.Ops.Apply(sources)
+	}
+	if err == nil && s.Interpolator != nil {
+		sources, err = s.Interpolator.Interpolate(sources)
+	}
+	if err == nil && s.Schemas != nil {
+		err = s.Schemas.Validate(sources, s.Cipher)
+	}
+	return sources, err
+}
+
+// loadCache serves the cached documents after loadErr failed the first
+// Load. They are reported as stale until a Load succeeds.
+func (s *Server) loadCache(loadErr error) error {
+	sources, savedAt, err := s.Cache.Load()
+	if err != nil {
+		return fmt.Errorf("%s, and no cached config can be served: %s", loadErr, err)
+	}
+
+	s.mu.Lock()
+	if s.loadedAt.IsZero() {
+		s.replaceSources(sources, savedAt)
+	}
+	s.mu.Unlock()
+
+	s.Log.Printf("Unable to load config, serving the config cached at %s until backends recover: %s", savedAt.Format(time.RFC3339), loadErr)
+	return nil
+}
+
+// retryLoads reloads the backends in the background, waiting RetryInterval
+// and then twice as long after each failure, until a Load succeeds or the
+// streams are closed. Only one such loop runs at a time.
+func (s *Server) retryLoads() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retrying {
+		return
+	}
+	s.retrying = true
+
+	interval := s.RetryInterval
+	if interval == 0 {
+		interval = DefaultRetryInterval
+	}
+	go func() {
+		for !s.recovered() {
+			select {
+			case <-s.closing:
+				s.mu.Lock()
+				s.retrying = false
+				s.mu.Unlock()
+				return
+			case <-time.After(interval):
+			}
+
+			changed, err := s.Refresh()
+			if err != nil {
+				s.Log.Printf("Unable to reload config, still serving stale documents: %s", err)
+				if interval *= 2; interval > MaxRetryInterval {
+					interval = MaxRetryInterval
+				}
+				continue
+			}
+			s.Log.Println("Config backends recovered, no longer serving stale documents.")
+			s.logChanges(changed)
+		}
+	}()
+}
+
+// recovered reports whether the last Load succeeded, ending retryLoads.
+func (s *Server) recovered() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loadErr != nil {
+		return false
+	}
+	s.retrying = false
+	return true
+}
+
+// replaceSources serves sources loaded at loadedAt. s.mu must be held.
+func (s *Server) replaceSources(sources []*Source, loadedAt time.Time) {
+	s.sources = sources
+	s.loadedAt = loadedAt
+	s.generation++
+	s.cache = map[string]*resolvedDocument{}
+	s.labels = map[string][]*Source{}
+	s.notify()
+}
+
+// notify wakes the watch streams. s.mu must be held.
+func (s *Server) notify() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// Stale reports whether the last Load failed, so the documents served may
+// be out of date.
+func (s *Server) Stale() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loadErr != nil && !s.loadedAt.IsZero()
+}
+
+// markStale sets the X-Config-Stale header on responses served while the
+// documents are Stale.
+func (s *Server) markStale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if s.Stale() {
+			res.Header().Set("X-Config-Stale", "true")
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+// Handler returns the HTTP routes served by the sidecar.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/config/", s.authenticate(s.markStale(http.HandlerFunc(s.config))))
+	mux.Handle("/status", s.authenticate(http.HandlerFunc(s.status)))
+	mux.Handle("/refresh", s.authenticate(http.HandlerFunc(s.refresh)))
+	mux.Handle("/services/", s.authenticate(http.HandlerFunc(s.services)))
+	mux.Handle("/", s.authenticate(s.markStale(http.HandlerFunc(s.spring))))
+
+	// Probes and metrics reveal nothing about the config, so they need no
+	// token.
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/metrics", s.metricsHandler)
+	return s.instrument(mux)
+}
+
+// Sources returns the sources loaded by the last successful Load.
+func (s *Server) Sources() []*Source {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sources
+}
+
+func (s *Server) resolve(name string, profiles []string) ([]*Source, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Resolve(s.sources, name, profiles)
+}
+
+// resolvedDocument is a merged and decrypted document, with its JSON
+// encoding and version computed once.
+type resolvedDocument struct {
+	document map[string]interface{}
+	json     []byte
+	version  string
+}
+
+func newResolvedDocument(document map[string]interface{}) (*resolvedDocument, error) {
+	js, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	return &resolvedDocument{document: document, json: js, version: jsonVersion(js)}, nil
+}
+
+// resolveDocument returns the merged and decrypted document for name and
+// profiles, or its pinned version. Documents are cached until the next
+// successful Load, and must not be modified by the caller.
+func (s *Server) resolveDocument(name string, profiles []string) (*resolvedDocument, bool, error) {
+	key := documentKey(name, profiles)
+	if pinned, isPinned := s.pinned(name, profiles); isPinned {
+		return pinned.resolved, true, nil
+	}
+	s.mu.RLock()
+	resolved, hit := s.cache[key]
+	s.mu.RUnlock()
+	s.metrics.observeCache(hit)
+	if hit {
+		return resolved, true, nil
+	}
+
+	resolved, found, generation, err := s.currentDocument(key, name, profiles)
+	if err == nil && found {
+		s.mu.Lock()
+		if s.generation == generation {
+			s.cache[key] = resolved
+		}
+		s.mu.Unlock()
+		s.saveHistory()
+	}
+	return resolved, found, err
+}
+
+// currentDocument resolves the document for name and profiles as
+// computeDocument does, and records new versions in its history. It also
+// returns the generation of the sources it resolved, which are not recorded
+// if they were replaced meanwhile.
+func (s *Server) currentDocument(key, name string, profiles []string) (*resolvedDocument, bool, uint64, error) {
+	resolved, found, loadedAt, generation, err := s.computeDocument(name, profiles)
+	if err == nil && found {
+		s.mu.Lock()
+		if s.generation == generation {
+			s.recordVersion(key, resolved, loadedAt)
+		}
+		s.mu.Unlock()
+	}
+	return resolved, found, generation, err
+}
+
+// computeDocument resolves the document for name and profiles from the
+// loaded sources, bypassing the cache, and returns when and from which
+// generation of the sources.
+func (s *Server) computeDocument(name string, profiles []string) (*resolvedDocument, bool, time.Time, uint64, error) {
+	s.mu.RLock()
+	sources, found := Resolve(s.sources, name, profiles)
+	loadedAt, generation := s.loadedAt, s.generation
+	s.mu.RUnlock()
+
+	document, err := s.Cipher.DecryptDocument(MergeSources(sources))
+	if err != nil {
+		return nil, found, loadedAt, generation, err
+	}
+	resolved, err := newResolvedDocument(document)
+	return resolved, found, loadedAt, generation, err
+}
+
+// decryptSources returns copies of sources with their values decrypted.
+func (s *Server) decryptSources(sources []*Source) ([]*Source, error) {
+	decrypted := make([]*Source, len(sources))
+	for i, source := range sources {
+		data, err := s.Cipher.DecryptDocument(source.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", source.Origin, err)
+		}
+		decrypted[i] = &Source{Name: source.Name, Origin: source.Origin, Data: data, Removed: source.Removed}
+	}
+	return decrypted, nil
+}
+
+// config serves /config/<name>[/<profile>]. The profile may be a comma
+// separated list. A bare /config/ serves the shared application document.
+// The format is selected by a file extension ending the path, such as
+// /config/billing.yml, or else by the Accept header. Paths ending in /watch
+// stream changes to the document, in /history list its versions and in
+// /pin pin it to one of them. A version query parameter serves an earlier
+// version, a label query parameter the branch, tag or commit of labeled
+// backends, a path query parameter selects a fragment of the document, and
+// explain=true describes where its keys come from instead.
+func (s *Server) config(res http.ResponseWriter, req *http.Request) {
+	path, action := parseActionPath(strings.TrimPrefix(req.URL.Path, "/config/"))
+
+	var format *Format
+	if action == "" {
+		path, format = splitFormatExtension(path)
+	}
+	if format == nil && action == "" {
+		var ok bool
+		if format, ok = NegotiateFormat(req.Header.Get("Accept")); !ok {
+			http.Error(res, "supported media types: "+supportedMediaTypes(), http.StatusNotAcceptable)
+			return
+		}
+		res.Header().Set("Vary", "Accept")
+	}
+
+	name, profiles, ok := parseConfigPath(path)
+	if !ok {
+		http.NotFound(res, req)
+		return
+	}
+	switch action {
+	case "watch":
+		s.watch(res, req, name, profiles)
+		return
+	case "history":
+		s.history(res, req, name, profiles)
+		return
+	case "pin":
+		s.pin(res, req, name, profiles)
+		return
+	}
+	if req.URL.Query().Get("explain") == "true" {
+		s.explain(res, req, name, profiles)
+		return
+	}
+
+	label := req.URL.Query().Get("label")
+	resolved, found, err := s.labelDocument(name, profiles, label)
+	if s.labelFailed(res, label, err) {
+		return
+	}
+	if version := req.URL.Query().Get("version"); version != "" && found {
+		previous, ok, err := s.findVersion(name, profiles, version, s.viewVersion(req, name))
+		if err != nil {
+			s.Log.Printf("Unable to find version %s of config %q: %s", version, name, err)
+			http.Error(res, "unable to find config version", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			s.Log.Printf("Received a request for unknown version %s of config %q.", version, name)
+			http.Error(res, fmt.Sprintf("version %q of config %q not found", version, name), http.StatusNotFound)
+			return
+		}
+		resolved, err = previous.resolved, nil
+	}
+	if !found {
+		s.Log.Printf("Received a request for unknown config %q.", name)
+		http.Error(res, fmt.Sprintf("config %q not found", name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.Log.Printf("Unable to resolve config %q: %s", name, err)
+		http.Error(res, "unable to decrypt config", http.StatusInternalServerError)
+		return
+	}
+	if resolved, err = s.authorize(req, name, resolved); err != nil {
+		s.Log.Printf("Unable to filter config %q: %s", name, err)
+		http.Error(res, "unable to filter config", http.StatusInternalServerError)
+		return
+	}
+	if query, ok := req.URL.Query()["path"]; ok {
+		s.query(res, name, query[0], resolved, format, req.Header.Get("If-None-Match"))
+		return
+	}
+
+	// Each format is a different representation, so needs its own ETag.
+	etag := `"` + resolved.version + `"`
+	if format != Formats[0] {
+		etag = `"` + resolved.version + "-" + format.Name + `"`
+	}
+	res.Header().Set("ETag", etag)
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	s.Log.Printf("Received a request for config %q as %s.", name, format.Name)
+	res.Header().Set("Content-Type", format.ContentType())
+	if format == Formats[0] {
+		res.Write(append(resolved.json, '\n'))
+		return
+	}
+	if err := format.Write(res, resolved.document); err != nil {
+		s.Log.Printf("Unable to write config %q as %s: %s", name, format.Name, err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches etag. Weak
+// validators match their strong equivalent, as RFC 7232 requires for GET.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseConfigPath(path string) (string, []string, bool) {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return DefaultName, nil, true
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return parts[0], nil, true
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return parts[0], splitList(parts[1]), true
+	default:
+		return "", nil, false
+	}
+}
@@ -0,0 +1,185 @@
+package configserver_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sample3-sidecar/configserver"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var server *configserver.Server
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		return res
+	}
+
+	BeforeEach(func() {
+		server = newServer(
+			&configserver.Source{Name: "application", Data: map[string]interface{}{"Scope": "some-service.admin", "Password": "secret"}},
+			&configserver.Source{Name: "application", Data: map[string]interface{}{"Password": "not-a-real-p4$$w0rd"}},
+			&configserver.Source{Name: "billing", Data: map[string]interface{}{"Password": "billing"}},
+			&configserver.Source{Name: "billing-prod", Data: map[string]interface{}{"Password": "billing-prod"}},
+		)
+	})
+
+	It("serves the application document under /config/", func() {
+		res := get("/config/")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(MatchJSON(`{"Scope":"some-service.admin","Password":"not-a-real-p4$$w0rd"}`))
+	})
+
+	It("serves named documents layered over the application document", func() {
+		res := get("/config/billing")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(MatchJSON(`{"Scope":"some-service.admin","Password":"billing"}`))
+	})
+
+	It("serves profile specific documents", func() {
+		res := get("/config/billing/prod")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(MatchJSON(`{"Scope":"some-service.admin","Password":"billing-prod"}`))
+	})
+
+	It("never caches documents resolved from sources a reload replaced", func() {
+		backend := &flakyBackend{}
+		server = configserver.NewServer([]configserver.Backend{backend}, log.New(ioutil.Discard, "", 0))
+		for round := 0; round < 50; round++ {
+			stop := make(chan struct{})
+			var readers sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							get("/config/billing")
+						}
+					}
+				}()
+			}
+
+			password := fmt.Sprintf("password-%d", round)
+			backend.heal([]*configserver.Source{{Name: "billing", Data: map[string]interface{}{"Password": password}}})
+			Expect(server.Load()).To(Succeed())
+			close(stop)
+			readers.Wait()
+			Expect(get("/config/billing").Body.String()).To(MatchJSON(`{"Password": "` + password + `"}`))
+		}
+	})
+
+	It("returns 404 for unknown names", func() {
+		Expect(get("/config/unknown").Code).To(Equal(http.StatusNotFound))
+		Expect(get("/config/unknown/prod").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("returns 404 for paths with too many segments", func() {
+		Expect(get("/config/billing/prod/extra").Code).To(Equal(http.StatusNotFound))
+	})
+
+	Describe("caching headers", func() {
+		conditionalGet := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("If-None-Match", ifNoneMatch)
+			res := httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, req)
+			return res
+		}
+
+		It("serves JSON with a stable ETag per document", func() {
+			res := get("/config/billing")
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+			etag := res.Header().Get("ETag")
+			Expect(etag).To(MatchRegexp(`^"[0-9a-f]{16}"$`))
+
+			Expect(get("/config/billing").Header().Get("ETag")).To(Equal(etag))
+			Expect(get("/config/billing/prod").Header().Get("ETag")).NotTo(Equal(etag))
+		})
+
+		It("returns 304 when If-None-Match holds the current ETag", func() {
+			etag := get("/config/billing").Header().Get("ETag")
+
+			res := conditionalGet("/config/billing", `"0000000000000000", `+etag)
+			Expect(res.Code).To(Equal(http.StatusNotModified))
+			Expect(res.Header().Get("ETag")).To(Equal(etag))
+			Expect(res.Body.Len()).To(BeZero())
+
+			Expect(conditionalGet("/config/billing", "W/"+etag).Code).To(Equal(http.StatusNotModified))
+			Expect(conditionalGet("/config/billing", "*").Code).To(Equal(http.StatusNotModified))
+		})
+
+		It("serves the document when the ETag is stale", func() {
+			res := conditionalGet("/config/billing", `"0000000000000000"`)
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(MatchJSON(`{"Scope":"some-service.admin","Password":"billing"}`))
+		})
+
+		It("changes the ETag when the document changes", func() {
+			etag := get("/config/billing").Header().Get("ETag")
+
+			server = newServer(&configserver.Source{Name: "billing", Data: map[string]interface{}{"Password": "rotated"}})
+			Expect(conditionalGet("/config/billing", etag).Code).To(Equal(http.StatusOK))
+		})
+	})
+	Describe("formats", func() {
+		accept := func(path, mediaType string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Accept", mediaType)
+			res := httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, req)
+			return res
+		}
+
+		It("selects the format by extension", func() {
+			res := get("/config/billing.yml")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/x-yaml"))
+			Expect(res.Body.String()).To(MatchYAML("Scope: some-service.admin\nPassword: billing\n"))
+
+			res = get("/config/billing/prod.env")
+			Expect(res.Header().Get("Content-Type")).To(Equal("text/x-dotenv"))
+			Expect(res.Body.String()).To(Equal("PASSWORD=\"billing-prod\"\nSCOPE=\"some-service.admin\"\n"))
+
+			res = get("/config/billing.properties")
+			Expect(res.Header().Get("Content-Type")).To(Equal("text/x-java-properties"))
+			Expect(res.Body.String()).To(Equal("Password: billing\nScope: some-service.admin\n"))
+
+			Expect(get("/config/billing.json").Body.String()).To(MatchJSON(`{"Scope":"some-service.admin","Password":"billing"}`))
+		})
+
+		It("selects the format by Accept header", func() {
+			res := accept("/config/billing", "text/x-dotenv")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("text/x-dotenv"))
+			Expect(res.Header().Get("Vary")).To(Equal("Accept"))
+			Expect(res.Body.String()).To(ContainSubstring(`PASSWORD="billing"`))
+		})
+
+		It("prefers the extension over the Accept header", func() {
+			res := accept("/config/billing.yml", "application/json")
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/x-yaml"))
+		})
+
+		It("returns 406 when no acceptable format is supported", func() {
+			res := accept("/config/billing", "text/html")
+			Expect(res.Code).To(Equal(http.StatusNotAcceptable))
+			Expect(res.Body.String()).To(ContainSubstring("application/json"))
+		})
+
+		It("gives each format its own ETag", func() {
+			Expect(get("/config/billing.yml").Header().Get("ETag")).NotTo(Equal(get("/config/billing").Header().Get("ETag")))
+			Expect(get("/config/billing.yml").Header().Get("ETag")).To(HaveSuffix(`-yaml"`))
+		})
+	})
+})
@@ -0,0 +1,231 @@
+package configserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// ServiceInstance is a service bound to the app, as listed in
+// $VCAP_SERVICES.
+type ServiceInstance map[string]interface{}
+
+// Name returns the instance name.
+func (i ServiceInstance) Name() string {
+	name, _ := i["name"].(string)
+	return name
+}
+
+// HasTag reports whether the instance is tagged with tag.
+func (i ServiceInstance) HasTag(tag string) bool {
+	tags, _ := i["tags"].([]interface{})
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseServices parses $VCAP_SERVICES into the bound instances, ordered by
+// service label and then as listed. An empty string means no services are
+// bound.
+func ParseServices(vcap string) ([]ServiceInstance, error) {
+	if strings.TrimSpace(vcap) == "" {
+		return nil, nil
+	}
+
+	var byLabel map[string][]ServiceInstance
+	if err := json.Unmarshal([]byte(vcap), &byLabel); err != nil {
+		return nil, fmt.Errorf("unable to parse $VCAP_SERVICES: %s", err)
+	}
+
+	labels := make([]string, 0, len(byLabel))
+	for label := range byLabel {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var services []ServiceInstance
+	for _, label := range labels {
+		services = append(services, byLabel[label]...)
+	}
+	return services, nil
+}
+
+// FindService returns the instance with the given name.
+func FindService(services []ServiceInstance, name string) (ServiceInstance, bool) {
+	for _, instance := range services {
+		if instance.Name() == name {
+			return instance, true
+		}
+	}
+	return nil, false
+}
+
+// ServiceMapping copies a value from a bound service into a document.
+type ServiceMapping struct {
+	Document string
+	Key      string
+	Instance string
+	// Path is a gjson path within the instance, such as credentials.uri.
+	Path string
+	Line int
+}
+
+// ParseServiceMappings reads mappings of the form
+//
+//	db.url <- services.postgres.credentials.uri
+//
+// one per line. Mappings apply to the application document until a
+// "[<document>]" line selects another document. Blank lines and lines
+// starting with # are ignored.
+func ParseServiceMappings(r io.Reader) ([]ServiceMapping, error) {
+	var mappings []ServiceMapping
+	document := DefaultName
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || strings.HasPrefix(text, "#"):
+			continue
+		case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+			document = strings.TrimSpace(text[1 : len(text)-1])
+			if document == "" {
+				return nil, fmt.Errorf("line %d: missing document name", line)
+			}
+			continue
+		}
+
+		parts := strings.SplitN(text, "<-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected <key> <- services.<instance>.<path>", line)
+		}
+		key := strings.TrimSpace(parts[0])
+		ref := strings.Split(strings.TrimSpace(parts[1]), ".")
+		if key == "" || len(ref) < 3 || ref[0] != "services" || ref[1] == "" {
+			return nil, fmt.Errorf("line %d: expected <key> <- services.<instance>.<path>", line)
+		}
+		mappings = append(mappings, ServiceMapping{
+			Document: document,
+			Key:      key,
+			Instance: ref[1],
+			Path:     strings.Join(ref[2:], "."),
+			Line:     line,
+		})
+	}
+	return mappings, scanner.Err()
+}
+
+// ApplyServiceMappings builds a document for each document named in
+// mappings. It fails if a mapping refers to an instance that is not bound,
+// or to a path the instance does not have.
+func ApplyServiceMappings(services []ServiceInstance, mappings []ServiceMapping) (map[string]map[string]interface{}, error) {
+	documents := map[string]map[string]interface{}{}
+	for _, mapping := range mappings {
+		instance, ok := FindService(services, mapping.Instance)
+		if !ok {
+			return nil, fmt.Errorf("line %d: no bound service named %q", mapping.Line, mapping.Instance)
+		}
+		js, err := json.Marshal(instance)
+		if err != nil {
+			return nil, err
+		}
+		value := gjson.GetBytes(js, mapping.Path)
+		if !value.Exists() {
+			return nil, fmt.Errorf("line %d: service %q has no %s", mapping.Line, mapping.Instance, mapping.Path)
+		}
+
+		if documents[mapping.Document] == nil {
+			documents[mapping.Document] = map[string]interface{}{}
+		}
+		setNested(documents[mapping.Document], strings.Split(mapping.Key, "."), value.Value())
+	}
+	return documents, nil
+}
+
+// ServicesBackend loads documents built from bound services by the
+// mappings in a file.
+type ServicesBackend struct {
+	Services     []ServiceInstance
+	MappingsFile string
+}
+
+func (b *ServicesBackend) Name() string {
+	return "services"
+}
+
+func (b *ServicesBackend) Load() ([]*Source, error) {
+	file, err := os.Open(b.MappingsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mappings, err := ParseServiceMappings(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.MappingsFile, err)
+	}
+	documents, err := ApplyServiceMappings(b.Services, mappings)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.MappingsFile, err)
+	}
+	return sortedSources(documents, func(string) string { return "services:" + b.MappingsFile }), nil
+}
+
+// services serves /services/<instance> with a bound instance and
+// /services/by-tag/<tag> with every instance carrying the tag.
+func (s *Server) services(res http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/services/"), "/")
+	parts := strings.Split(path, "/")
+
+	var body interface{}
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		instance, ok := FindService(s.Services, parts[0])
+		if !ok {
+			http.Error(res, fmt.Sprintf("service %q not found", parts[0]), http.StatusNotFound)
+			return
+		}
+		body = s.filterService(req, instance)
+	case len(parts) == 2 && parts[0] == "by-tag" && parts[1] != "":
+		tagged := []interface{}{}
+		for _, instance := range s.Services {
+			if instance.HasTag(parts[1]) {
+				tagged = append(tagged, s.filterService(req, instance))
+			}
+		}
+		body = tagged
+	default:
+		http.NotFound(res, req)
+		return
+	}
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		s.Log.Printf("Unable to marshal services for %s: %s", req.URL.Path, err)
+		http.Error(res, "unable to marshal services", http.StatusInternalServerError)
+		return
+	}
+	s.Log.Printf("Received a request for services %q.", path)
+	res.Header().Set("Content-Type", "application/json")
+	res.Write(js)
+}
+
+// filterService applies the access policy to an instance, whose keys are
+// matched as services.<instance>.<key>.
+func (s *Server) filterService(req *http.Request, instance ServiceInstance) map[string]interface{} {
+	filtered := s.filter(req, "services", map[string]interface{}{instance.Name(): map[string]interface{}(instance)})
+	body, _ := filtered[instance.Name()].(map[string]interface{})
+	if body == nil {
+		body = map[string]interface{}{}
+	}
+	return body
+}
@@ -0,0 +1,167 @@
+package configserver_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const vcapServices = `{
+  "elephantsql": [{
+    "name": "postgres",
+    "label": "elephantsql",
+    "tags": ["postgres", "relational"],
+    "credentials": {"uri": "postgres://user:pass@db/app", "hosts": ["db1", "db2"], "port": 5432}
+  }],
+  "p.redis": [{
+    "name": "cache",
+    "label": "p.redis",
+    "tags": ["redis"],
+    "credentials": {"host": "redis", "password": "secret"}
+  }, {
+    "name": "sessions",
+    "label": "p.redis",
+    "tags": ["redis"],
+    "credentials": {"host": "sessions"}
+  }]
+}`
+
+var _ = Describe("Services", func() {
+	var services []configserver.ServiceInstance
+
+	BeforeEach(func() {
+		var err error
+		services, err = configserver.ParseServices(vcapServices)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("ParseServices", func() {
+		It("lists the instances by label", func() {
+			Expect(services).To(HaveLen(3))
+			Expect(services[0].Name()).To(Equal("postgres"))
+			Expect(services[1].Name()).To(Equal("cache"))
+			Expect(services[2].HasTag("redis")).To(BeTrue())
+		})
+
+		It("treats an unset variable as no services", func() {
+			Expect(configserver.ParseServices("")).To(BeEmpty())
+		})
+
+		It("fails on invalid JSON", func() {
+			_, err := configserver.ParseServices("{")
+			Expect(err).To(MatchError(ContainSubstring("unable to parse $VCAP_SERVICES")))
+		})
+	})
+
+	Describe("mappings", func() {
+		apply := func(mappings string) (map[string]map[string]interface{}, error) {
+			parsed, err := configserver.ParseServiceMappings(strings.NewReader(mappings))
+			Expect(err).NotTo(HaveOccurred())
+			return configserver.ApplyServiceMappings(services, parsed)
+		}
+
+		It("copies service values into documents", func() {
+			documents, err := apply(`
+# shared by every app
+db.url <- services.postgres.credentials.uri
+db.replica <- services.postgres.credentials.hosts.1
+
+[billing]
+cache <- services.cache.credentials
+`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(documents).To(Equal(map[string]map[string]interface{}{
+				"application": {"db": map[string]interface{}{"url": "postgres://user:pass@db/app", "replica": "db2"}},
+				"billing":     {"cache": map[string]interface{}{"host": "redis", "password": "secret"}},
+			}))
+		})
+
+		It("rejects malformed lines", func() {
+			_, err := configserver.ParseServiceMappings(strings.NewReader("db.url = postgres\n"))
+			Expect(err).To(MatchError("line 1: expected <key> <- services.<instance>.<path>"))
+
+			_, err = configserver.ParseServiceMappings(strings.NewReader("\ndb.url <- vcap.postgres.uri\n"))
+			Expect(err).To(MatchError("line 2: expected <key> <- services.<instance>.<path>"))
+		})
+
+		It("fails on unbound services and missing paths", func() {
+			_, err := apply("db.url <- services.mysql.credentials.uri")
+			Expect(err).To(MatchError(`line 1: no bound service named "mysql"`))
+
+			_, err = apply("db.url <- services.postgres.credentials.url")
+			Expect(err).To(MatchError(`line 1: service "postgres" has no credentials.url`))
+		})
+	})
+
+	Describe("ServicesBackend", func() {
+		It("loads the documents built by the mappings file", func() {
+			dir, err := ioutil.TempDir("", "configserver")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			file := filepath.Join(dir, "services.mappings")
+			Expect(ioutil.WriteFile(file, []byte("[billing]\ndb.port <- services.postgres.credentials.port\n"), 0644)).To(Succeed())
+
+			sources, err := (&configserver.ServicesBackend{Services: services, MappingsFile: file}).Load()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sources).To(HaveLen(1))
+			Expect(sources[0].Name).To(Equal("billing"))
+			Expect(sources[0].Origin).To(Equal("services:" + file))
+			Expect(sources[0].Data).To(Equal(map[string]interface{}{"db": map[string]interface{}{"port": 5432.0}}))
+		})
+	})
+
+	Describe("/services/", func() {
+		var server *configserver.Server
+
+		get := func(path string) *httptest.ResponseRecorder {
+			res := httptest.NewRecorder()
+			server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+			return res
+		}
+
+		BeforeEach(func() {
+			server = newServer()
+			server.Services = services
+		})
+
+		It("serves an instance by name", func() {
+			res := get("/services/cache")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(res.Body.String()).To(MatchJSON(`{
+				"name": "cache",
+				"label": "p.redis",
+				"tags": ["redis"],
+				"credentials": {"host": "redis", "password": "secret"}
+			}`))
+		})
+
+		It("serves every instance with a tag", func() {
+			res := get("/services/by-tag/redis")
+			Expect(res.Code).To(Equal(http.StatusOK))
+			Expect(res.Body.String()).To(ContainSubstring(`"name":"cache"`))
+			Expect(res.Body.String()).To(ContainSubstring(`"name":"sessions"`))
+			Expect(res.Body.String()).NotTo(ContainSubstring(`"name":"postgres"`))
+
+			Expect(get("/services/by-tag/mysql").Body.String()).To(MatchJSON(`[]`))
+		})
+
+		It("returns 404 for unknown instances", func() {
+			res := get("/services/mysql")
+			Expect(res.Code).To(Equal(http.StatusNotFound))
+			Expect(get("/services/").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("requires the token", func() {
+			server.Token = "s3cret"
+			Expect(get("/services/cache").Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
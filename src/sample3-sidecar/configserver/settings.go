@@ -0,0 +1,325 @@
+package configserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSources are the glob patterns used when $CONFIG_SERVER_SOURCES is
+// not set. Relative patterns are resolved against $HOME, which is the app
+// directory inside a Cloud Foundry container.
+var DefaultSources = []string{"config/*.yml", "config/*.yaml", "config/*.json"}
+
+// DefaultServiceMappings is the file read by the services backend when
+// $CONFIG_SERVER_SERVICE_MAPPINGS is not set.
+const DefaultServiceMappings = "config/services.mappings"
+
+// DefaultOpsDir holds a directory of ops files per space, applied when
+// $CONFIG_SERVER_OPS_FILES is not set.
+const DefaultOpsDir = "config/ops"
+
+// DefaultBackends are the backends used when $CONFIG_SERVER_BACKENDS is not
+// set.
+var DefaultBackends = []string{"file"}
+
+// DefaultDrainWindow and DefaultShutdownTimeout fit within the ten seconds
+// Cloud Foundry waits between SIGTERM and SIGKILL.
+const (
+	DefaultDrainWindow     = 5 * time.Second
+	DefaultShutdownTimeout = 3 * time.Second
+)
+
+// DefaultReloadDelay is how long file changes are collected before the
+// documents are reloaded.
+const DefaultReloadDelay = 250 * time.Millisecond
+
+// Settings holds the sidecar configuration read from the environment.
+type Settings struct {
+	Port      string
+	Socket    string
+	Token     string
+	Sources   []string
+	Backends  []string
+	EnvPrefix string
+	Vault     HTTPBackendSettings
+	CredHub   HTTPBackendSettings
+	Git       GitSettings
+
+	EncryptKey     string
+	EncryptKeyFile string
+
+	DrainWindow     time.Duration
+	ShutdownTimeout time.Duration
+
+	Watch       bool
+	ReloadDelay time.Duration
+	Heartbeat   time.Duration
+
+	// Services are the instances bound to the app in $VCAP_SERVICES.
+	Services        []ServiceInstance
+	ServiceMappings string
+
+	VarsFiles     []string
+	VarsEnvPrefix string
+	StrictVars    bool
+	// VarsStore is "vault", "credhub" or the path of a YAML file.
+	VarsStore string
+
+	// PolicyFile is the access policy, which must exist when PolicyRequired
+	// is set by $CONFIG_SERVER_POLICY.
+	PolicyFile     string
+	PolicyRequired bool
+
+	// SchemaDir holds the JSON Schemas of the documents.
+	SchemaDir string
+
+	// HistorySize is how many versions of each document are kept.
+	HistorySize int
+
+	// CacheFile persists the last loaded documents, encrypted with
+	// CacheKey or else the encryption key. ServeStale serves them when the
+	// backends fail at start.
+	CacheFile  string
+	CacheKey   string
+	ServeStale bool
+
+	// Space is the Cloud Foundry space the app runs in, from
+	// $VCAP_APPLICATION.
+	Space    string
+	OpsFiles []string
+}
+
+// HTTPBackendSettings configures a backend reached over HTTP.
+type HTTPBackendSettings struct {
+	Address           string
+	Token             string
+	Mount             string
+	Prefix            string
+	SkipSSLValidation bool
+	ClientCert        string
+	ClientKey         string
+}
+
+// GitSettings configures the git backend.
+type GitSettings struct {
+	Repository  string
+	Label       string
+	SearchPaths []string
+}
+
+// NewSettings reads the sidecar settings using the given getenv function,
+// normally os.Getenv.
+func NewSettings(getenv func(string) string) (Settings, error) {
+	settings := Settings{
+		Port:      getenv("CONFIG_SERVER_PORT"),
+		Socket:    getenv("CONFIG_SERVER_SOCKET"),
+		Token:     getenv("CONFIG_SERVER_TOKEN"),
+		Sources:   splitList(getenv("CONFIG_SERVER_SOURCES")),
+		Backends:  splitList(getenv("CONFIG_SERVER_BACKENDS")),
+		EnvPrefix: getenv("CONFIG_SERVER_ENV_PREFIX"),
+		Vault: HTTPBackendSettings{
+			Address:           firstNonEmpty(getenv("CONFIG_SERVER_VAULT_ADDR"), getenv("VAULT_ADDR")),
+			Token:             firstNonEmpty(getenv("CONFIG_SERVER_VAULT_TOKEN"), getenv("VAULT_TOKEN")),
+			Mount:             firstNonEmpty(getenv("CONFIG_SERVER_VAULT_MOUNT"), "secret"),
+			Prefix:            getenv("CONFIG_SERVER_VAULT_PREFIX"),
+			SkipSSLValidation: getenv("CONFIG_SERVER_VAULT_SKIP_SSL_VALIDATION") == "true",
+		},
+		CredHub: HTTPBackendSettings{
+			Address:           firstNonEmpty(getenv("CONFIG_SERVER_CREDHUB_URL"), getenv("CREDHUB_API")),
+			Token:             getenv("CONFIG_SERVER_CREDHUB_TOKEN"),
+			Prefix:            firstNonEmpty(getenv("CONFIG_SERVER_CREDHUB_PREFIX"), DefaultCredHubPrefix),
+			SkipSSLValidation: getenv("CONFIG_SERVER_CREDHUB_SKIP_SSL_VALIDATION") == "true",
+			ClientCert:        getenv("CF_INSTANCE_CERT"),
+			ClientKey:         getenv("CF_INSTANCE_KEY"),
+		},
+		Git: GitSettings{
+			Repository:  firstNonEmpty(getenv("CONFIG_SERVER_GIT_REPO"), DefaultGitRepository),
+			Label:       firstNonEmpty(getenv("CONFIG_SERVER_GIT_LABEL"), DefaultGitLabel),
+			SearchPaths: splitList(getenv("CONFIG_SERVER_GIT_SEARCH_PATHS")),
+		},
+		EncryptKey:      getenv("CONFIG_SERVER_ENCRYPT_KEY"),
+		EncryptKeyFile:  getenv("CONFIG_SERVER_ENCRYPT_KEY_FILE"),
+		Watch:           getenv("CONFIG_SERVER_WATCH") != "false",
+		ServiceMappings: firstNonEmpty(getenv("CONFIG_SERVER_SERVICE_MAPPINGS"), DefaultServiceMappings),
+		VarsFiles:       splitList(getenv("CONFIG_SERVER_VARS_FILES")),
+		VarsEnvPrefix:   firstNonEmpty(getenv("CONFIG_SERVER_VARS_ENV"), DefaultVarsEnvPrefix),
+		StrictVars:      getenv("CONFIG_SERVER_STRICT_VARS") == "true",
+		VarsStore:       getenv("CONFIG_SERVER_VARS_STORE"),
+		SchemaDir:       firstNonEmpty(getenv("CONFIG_SERVER_SCHEMAS"), DefaultSchemaDir),
+		CacheFile:       getenv("CONFIG_SERVER_CACHE_FILE"),
+		CacheKey:        getenv("CONFIG_SERVER_CACHE_KEY"),
+	}
+
+	var err error
+	if settings.DrainWindow, err = parseDuration(getenv, "CONFIG_SERVER_DRAIN_WINDOW", DefaultDrainWindow); err != nil {
+		return Settings{}, err
+	}
+	if settings.ShutdownTimeout, err = parseDuration(getenv, "CONFIG_SERVER_SHUTDOWN_TIMEOUT", DefaultShutdownTimeout); err != nil {
+		return Settings{}, err
+	}
+	if settings.ReloadDelay, err = parseDuration(getenv, "CONFIG_SERVER_RELOAD_DELAY", DefaultReloadDelay); err != nil {
+		return Settings{}, err
+	}
+	if settings.Heartbeat, err = parseDuration(getenv, "CONFIG_SERVER_HEARTBEAT", DefaultHeartbeat); err != nil {
+		return Settings{}, err
+	}
+
+	settings.HistorySize = DefaultHistorySize
+	if size := getenv("CONFIG_SERVER_HISTORY_SIZE"); size != "" {
+		if settings.HistorySize, err = strconv.Atoi(size); err != nil || settings.HistorySize < 1 {
+			return Settings{}, fmt.Errorf("invalid $CONFIG_SERVER_HISTORY_SIZE %q: expected a positive number", size)
+		}
+	}
+	switch policy := getenv("CONFIG_SERVER_STALE_POLICY"); policy {
+	case "", "fail-fast":
+	case "serve-stale":
+		settings.ServeStale = true
+	default:
+		return Settings{}, fmt.Errorf("invalid $CONFIG_SERVER_STALE_POLICY %q: expected fail-fast or serve-stale", policy)
+	}
+
+	if settings.Services, err = ParseServices(getenv("VCAP_SERVICES")); err != nil {
+		return Settings{}, err
+	}
+	if settings.Space, err = parseSpace(getenv("VCAP_APPLICATION")); err != nil {
+		return Settings{}, err
+	}
+	settings.OpsFiles = opsFiles(getenv, settings.Space)
+
+	if len(settings.Sources) == 0 {
+		settings.Sources = DefaultSources
+	}
+	settings.Sources = resolvePaths(getenv("HOME"), settings.Sources)
+	if settings.Socket != "" {
+		settings.Socket = resolvePaths(getenv("HOME"), []string{settings.Socket})[0]
+	}
+	settings.ServiceMappings = resolvePaths(getenv("HOME"), []string{settings.ServiceMappings})[0]
+	settings.VarsFiles = resolvePaths(getenv("HOME"), settings.VarsFiles)
+	settings.OpsFiles = resolvePaths(getenv("HOME"), settings.OpsFiles)
+	settings.Git.Repository = resolvePaths(getenv("HOME"), []string{settings.Git.Repository})[0]
+	settings.SchemaDir = resolvePaths(getenv("HOME"), []string{settings.SchemaDir})[0]
+	settings.PolicyFile, settings.PolicyRequired = PolicyPath(getenv)
+	if settings.VarsStore != "" && settings.VarsStore != "vault" && settings.VarsStore != "credhub" {
+		settings.VarsStore = resolvePaths(getenv("HOME"), []string{settings.VarsStore})[0]
+	}
+	if settings.CacheFile != "" {
+		settings.CacheFile = resolvePaths(getenv("HOME"), []string{settings.CacheFile})[0]
+	}
+	if settings.EncryptKeyFile != "" {
+		settings.EncryptKeyFile = resolvePaths(getenv("HOME"), []string{settings.EncryptKeyFile})[0]
+	}
+
+	if len(settings.Backends) == 0 {
+		settings.Backends = DefaultBackends
+	}
+	for _, backend := range settings.Backends {
+		switch {
+		case backend == "vault" && settings.Vault.Address == "":
+			return Settings{}, errors.New("missing $CONFIG_SERVER_VAULT_ADDR for the vault backend")
+		case backend == "credhub" && settings.CredHub.Address == "":
+			return Settings{}, errors.New("missing $CONFIG_SERVER_CREDHUB_URL for the credhub backend")
+		}
+	}
+	switch {
+	case settings.VarsStore == "vault" && settings.Vault.Address == "":
+		return Settings{}, errors.New("missing $CONFIG_SERVER_VAULT_ADDR for the vault vars store")
+	case settings.VarsStore == "credhub" && settings.CredHub.Address == "":
+		return Settings{}, errors.New("missing $CONFIG_SERVER_CREDHUB_URL for the credhub vars store")
+	}
+	switch {
+	case settings.ServeStale && settings.CacheFile == "":
+		return Settings{}, errors.New("missing $CONFIG_SERVER_CACHE_FILE for the serve-stale policy")
+	case settings.CacheFile != "" && settings.CacheKey == "" && settings.EncryptKey == "" && settings.EncryptKeyFile == "":
+		return Settings{}, errors.New("missing $CONFIG_SERVER_CACHE_KEY or $CONFIG_SERVER_ENCRYPT_KEY to encrypt the cache file")
+	}
+
+	return settings, nil
+}
+
+// parseSpace returns the space name from $VCAP_APPLICATION, or "" when it
+// is not set.
+func parseSpace(vcap string) (string, error) {
+	if strings.TrimSpace(vcap) == "" {
+		return "", nil
+	}
+	var application struct {
+		SpaceName string `json:"space_name"`
+	}
+	if err := json.Unmarshal([]byte(vcap), &application); err != nil {
+		return "", fmt.Errorf("unable to parse $VCAP_APPLICATION: %s", err)
+	}
+	return application.SpaceName, nil
+}
+
+// opsFiles returns the ops files listed in $CONFIG_SERVER_OPS_FILES or,
+// when it is not set, every ops file in the directory named after space.
+func opsFiles(getenv func(string) string, space string) []string {
+	if files := splitList(getenv("CONFIG_SERVER_OPS_FILES")); len(files) > 0 {
+		return files
+	}
+	if space == "" {
+		return nil
+	}
+	dir := filepath.Join(firstNonEmpty(getenv("CONFIG_SERVER_OPS_DIR"), DefaultOpsDir), space)
+	return []string{filepath.Join(dir, "*.yml"), filepath.Join(dir, "*.yaml")}
+}
+
+// splitList splits a comma separated environment variable, dropping blanks.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseDuration(getenv func(string) string, name string, fallback time.Duration) (time.Duration, error) {
+	value := getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid $%s %q: expected a duration such as 5s", name, value)
+	}
+	return duration, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// privateFiles returns the files the sidecar reads that must never be
+// served, even when the source patterns match them: the access policy,
+// the variables files and the file vars store.
+func (s Settings) privateFiles() []string {
+	files := append([]string{s.PolicyFile}, s.VarsFiles...)
+	if s.VarsStore != "" && s.VarsStore != "vault" && s.VarsStore != "credhub" {
+		files = append(files, s.VarsStore)
+	}
+	return files
+}
+
+func resolvePaths(home string, paths []string) []string {
+	resolved := make([]string, len(paths))
+	for i, path := range paths {
+		if filepath.IsAbs(path) || home == "" {
+			resolved[i] = path
+		} else {
+			resolved[i] = filepath.Join(home, path)
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,163 @@
+package configserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Source is a single configuration document loaded by a backend. Name is
+// the document it belongs to, e.g. "billing-prod" for billing-prod.yml, and
+// Origin identifies where it was loaded from. Removed lists the flattened
+// keys the source removed from the sources before it, as ops do.
+type Source struct {
+	Name    string
+	Origin  string
+	Data    map[string]interface{}
+	Removed []string
+}
+
+// LoadSources expands each glob pattern and parses every YAML or JSON file it
+// matches. Files are returned in pattern order, sorted within each pattern,
+// so that later files take precedence when the sources are merged.
+func LoadSources(patterns []string) ([]*Source, error) {
+	return loadSources(patterns, nil)
+}
+
+// loadSources is LoadSources skipping the files in exclude.
+func loadSources(patterns, exclude []string) ([]*Source, error) {
+	var sources []*Source
+	seen := map[string]bool{}
+	for _, path := range exclude {
+		seen[absPath(path)] = true
+	}
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config source pattern %q: %s", pattern, err)
+		}
+		if len(matches) == 0 && !hasGlobMeta(pattern) {
+			return nil, fmt.Errorf("config source %s does not exist", pattern)
+		}
+		sort.Strings(matches)
+
+		for _, path := range matches {
+			if seen[absPath(path)] {
+				continue
+			}
+			seen[absPath(path)] = true
+
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read config source %s: %s", path, err)
+			}
+			if info.IsDir() {
+				continue
+			}
+
+			source, err := LoadSource(path)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, source)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no config files match %s", strings.Join(patterns, ", "))
+	}
+	return sources, nil
+}
+
+// absPath returns path made absolute, or cleaned when that fails, so that
+// different spellings of a file compare equal.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// LoadSource parses a single YAML or JSON config file. The format is chosen
+// by the file extension and the document must be a mapping at the top level.
+func LoadSource(path string) (*Source, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config source %s: %s", path, err)
+	}
+
+	data, err := parseDocument(path, contents)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config source %s: %s", path, err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &Source{Name: name, Origin: "file:" + path, Data: data}, nil
+}
+
+func parseDocument(path string, contents []byte) (map[string]interface{}, error) {
+	var raw interface{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if len(strings.TrimSpace(string(contents))) == 0 {
+			return map[string]interface{}{}, nil
+		}
+		if err := json.Unmarshal(contents, &raw); err != nil {
+			return nil, err
+		}
+	case ".yml", ".yaml":
+		if err := yaml.Unmarshal(contents, &raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q", filepath.Ext(path))
+	}
+
+	if raw == nil {
+		return map[string]interface{}{}, nil
+	}
+	data, ok := normalize(raw).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping at the top level, found %T", raw)
+	}
+	return data, nil
+}
+
+// normalize converts the map[interface{}]interface{} values produced by the
+// YAML decoder into map[string]interface{} so documents can be marshalled
+// as JSON.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalize(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalize(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = normalize(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
@@ -0,0 +1,81 @@
+package configserver_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sources", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "configserver")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	writeFile := func(name, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	It("loads YAML and JSON files matched by the patterns in order", func() {
+		writeFile("config/b.yml", "scope: b\nnested:\n  key: value\n")
+		writeFile("config/a.json", `{"scope": "a", "list": [1, 2]}`)
+
+		sources, err := configserver.LoadSources([]string{
+			filepath.Join(dir, "config", "*.json"),
+			filepath.Join(dir, "config", "*.yml"),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sources).To(HaveLen(2))
+		Expect(sources[0].Origin).To(Equal("file:" + filepath.Join(dir, "config", "a.json")))
+		Expect(sources[0].Data).To(HaveKeyWithValue("list", []interface{}{float64(1), float64(2)}))
+		Expect(sources[1].Data).To(Equal(map[string]interface{}{
+			"scope":  "b",
+			"nested": map[string]interface{}{"key": "value"},
+		}))
+	})
+
+	It("skips directories matched by a pattern", func() {
+		writeFile("config/app.yml", "a: 1\n")
+		Expect(os.MkdirAll(filepath.Join(dir, "config", "dir.yml"), 0755)).To(Succeed())
+
+		sources, err := configserver.LoadSources([]string{filepath.Join(dir, "config", "*.yml")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sources).To(HaveLen(1))
+	})
+
+	It("fails when a literal path does not exist", func() {
+		_, err := configserver.LoadSources([]string{filepath.Join(dir, "missing.yml")})
+		Expect(err).To(MatchError(ContainSubstring("missing.yml does not exist")))
+	})
+
+	It("fails when no files match any pattern", func() {
+		_, err := configserver.LoadSources([]string{filepath.Join(dir, "*.yml")})
+		Expect(err).To(MatchError(ContainSubstring("no config files match")))
+	})
+
+	It("fails with the file name when a file is malformed", func() {
+		writeFile("config/bad.yml", "key: [unterminated\n")
+
+		_, err := configserver.LoadSources([]string{filepath.Join(dir, "config", "*.yml")})
+		Expect(err).To(MatchError(ContainSubstring("unable to parse config source " + filepath.Join(dir, "config", "bad.yml"))))
+	})
+
+	It("fails when a file is not a mapping", func() {
+		writeFile("config/list.yml", "- a\n- b\n")
+
+		_, err := configserver.LoadSources([]string{filepath.Join(dir, "config", "list.yml")})
+		Expect(err).To(MatchError(ContainSubstring("expected a mapping at the top level")))
+	})
+})
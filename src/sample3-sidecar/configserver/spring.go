@@ -0,0 +1,172 @@
+package configserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SpringDefaultProfile is the profile Spring clients request when no
+// profile is active.
+const SpringDefaultProfile = "default"
+
+// Environment is the response body of the Spring Cloud Config Server
+// /{application}/{profile}[/{label}] endpoint.
+type Environment struct {
+	Name            string           `json:"name"`
+	Profiles        []string         `json:"profiles"`
+	Label           *string          `json:"label"`
+	Version         *string          `json:"version"`
+	State           *string          `json:"state"`
+	PropertySources []PropertySource `json:"propertySources"`
+}
+
+// PropertySource is a single flattened source within an Environment.
+type PropertySource struct {
+	Name   string                 `json:"name"`
+	Source map[string]interface{} `json:"source"`
+}
+
+// NewEnvironment builds the Spring view of the given sources, which must be
+// ordered from lowest to highest precedence. Spring lists property sources
+// highest precedence first.
+func NewEnvironment(name string, profiles []string, label string, sources []*Source) Environment {
+	env := Environment{
+		Name:            name,
+		Profiles:        profiles,
+		PropertySources: []PropertySource{},
+	}
+	if label != "" {
+		env.Label = &label
+	}
+
+	for i := len(sources) - 1; i >= 0; i-- {
+		env.PropertySources = append(env.PropertySources, PropertySource{
+			Name:   sources[i].Origin,
+			Source: Flatten(sources[i].Data),
+		})
+	}
+	return env
+}
+
+// spring serves the Spring Cloud Config Server API:
+//
+//	/{application}/{profile}[/{label}]
+//	/[{label}/]{application}-{profile}.{yml,yaml,properties,json}
+//
+// Unlike /config/, unknown applications resolve to the shared application
+// document, matching the behaviour Spring clients expect.
+func (s *Server) spring(res http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	if ext := path.Ext(parts[len(parts)-1]); len(parts) <= 2 && isSpringFormat(ext) {
+		file := strings.TrimSuffix(parts[len(parts)-1], ext)
+		i := strings.LastIndex(file, "-")
+		if i <= 0 || i == len(file)-1 {
+			http.NotFound(res, req)
+			return
+		}
+		label := ""
+		if len(parts) == 2 {
+			label = springLabel(parts[0])
+		}
+		s.springDocument(res, req, file[:i], splitList(file[i+1:]), label, ext)
+		return
+	}
+
+	if len(parts) != 2 && len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(res, req)
+		return
+	}
+	label := ""
+	if len(parts) == 3 {
+		label = springLabel(parts[2])
+	}
+	s.springEnvironment(res, req, parts[0], splitList(parts[1]), label)
+}
+
+func (s *Server) springEnvironment(res http.ResponseWriter, req *http.Request, name string, profiles []string, label string) {
+	sources, err := s.springSources(name, profiles, label, s.viewVersion(req, name))
+	if s.labelFailed(res, label, err) {
+		return
+	}
+	if err != nil {
+		s.Log.Printf("Unable to decrypt environment %q: %s", name, err)
+		http.Error(res, "unable to decrypt environment", http.StatusInternalServerError)
+		return
+	}
+
+	for i, source := range sources {
+		sources[i] = &Source{Name: source.Name, Origin: source.Origin, Data: s.filter(req, name, source.Data)}
+	}
+
+	js, err := json.Marshal(NewEnvironment(name, profiles, label, sources))
+	if err != nil {
+		s.Log.Printf("Unable to marshal environment %q: %s", name, err)
+		http.Error(res, "unable to marshal environment", http.StatusInternalServerError)
+		return
+	}
+
+	s.Log.Printf("Received a Spring request for environment %q.", name)
+	res.Header().Set("Content-Type", "application/json")
+	res.Write(js)
+}
+
+// springSources returns the decrypted property sources of the environment
+// for name and profiles at label. A pinned document is served as a single
+// source holding the pinned version, as /config/ serves it.
+func (s *Server) springSources(name string, profiles []string, label string, view versionView) ([]*Source, error) {
+	if label == "" || !s.labeled() {
+		if pinned, ok := s.pinned(name, profiles); ok {
+			source, err := pinnedSource(name, pinned, view)
+			return []*Source{source}, err
+		}
+	}
+	all, err := s.labelSources(label)
+	if err != nil {
+		return nil, err
+	}
+	sources, _ := Resolve(all, name, profiles)
+	return s.decryptSources(sources)
+}
+
+func (s *Server) springDocument(res http.ResponseWriter, req *http.Request, name string, profiles []string, label string, ext string) {
+	resolved, _, err := s.labelDocument(name, profiles, label)
+	if s.labelFailed(res, label, err) {
+		return
+	}
+	if err != nil {
+		s.Log.Printf("Unable to decrypt %s%s: %s", name, ext, err)
+		http.Error(res, "unable to decrypt document", http.StatusInternalServerError)
+		return
+	}
+
+	document := s.filter(req, name, resolved.document)
+
+	s.Log.Printf("Received a Spring request for %s%s.", name, ext)
+	format := FormatForExtension(ext)
+	// Spring serves everything but JSON as plain text.
+	if format.Name == "json" {
+		res.Header().Set("Content-Type", format.ContentType())
+	} else {
+		res.Header().Set("Content-Type", "text/plain")
+	}
+	if err := format.Write(res, document); err != nil {
+		s.Log.Printf("Unable to write %s%s: %s", name, ext, err)
+	}
+}
+
+func isSpringFormat(ext string) bool {
+	switch ext {
+	case ".yml", ".yaml", ".properties", ".json":
+		return true
+	}
+	return false
+}
+
+// springLabel decodes the "(_)" sequence Spring clients use in place of "/"
+// in labels such as feature branch names.
+func springLabel(label string) string {
+	return strings.Replace(label, "(_)", "/", -1)
+}
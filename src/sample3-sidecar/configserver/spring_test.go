@@ -0,0 +1,126 @@
+package configserver_test
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sample3-sidecar/configserver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spring Cloud Config API", func() {
+	var server *configserver.Server
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		return res
+	}
+
+	BeforeEach(func() {
+		server = newServer(
+			&configserver.Source{Name: "application", Origin: "file:/app/config/application.yml", Data: map[string]interface{}{
+				"server": map[string]interface{}{"port": 8080},
+			}},
+			&configserver.Source{Name: "billing", Origin: "file:/app/config/billing.yml", Data: map[string]interface{}{
+				"db":    map[string]interface{}{"url": "postgres://localhost"},
+				"hosts": []interface{}{"a", "b"},
+			}},
+			&configserver.Source{Name: "billing-prod", Origin: "file:/app/config/billing-prod.yml", Data: map[string]interface{}{
+				"db": map[string]interface{}{"url": "postgres://prod"},
+			}},
+		)
+	})
+
+	It("serves the environment with flattened property sources, highest precedence first", func() {
+		res := get("/billing/prod")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(res.Body.String()).To(MatchJSON(`{
+			"name": "billing",
+			"profiles": ["prod"],
+			"label": null,
+			"version": null,
+			"state": null,
+			"propertySources": [
+				{"name": "file:/app/config/billing-prod.yml", "source": {"db.url": "postgres://prod"}},
+				{"name": "file:/app/config/billing.yml", "source": {"db.url": "postgres://localhost", "hosts[0]": "a", "hosts[1]": "b"}},
+				{"name": "file:/app/config/application.yml", "source": {"server.port": 8080}}
+			]
+		}`))
+	})
+
+	It("keeps the digits of large numbers in property sources and YAML", func() {
+		server = newServer(&configserver.Source{Name: "billing", Origin: "file:/app/config/billing.json", Data: map[string]interface{}{
+			"db": map[string]interface{}{"pool": 1e6, "account": 12345678901.0},
+		}})
+
+		body := get("/billing/default").Body.String()
+		Expect(body).To(ContainSubstring(`"db.pool":1000000`))
+		Expect(body).To(ContainSubstring(`"db.account":12345678901`))
+		Expect(get("/billing-default.properties").Body.String()).To(Equal("db.account: 12345678901\ndb.pool: 1000000\n"))
+		Expect(get("/billing-default.yml").Body.String()).To(Equal("db:\n  account: 12345678901\n  pool: 1000000\n"))
+	})
+
+	It("echoes the label, decoding (_) as /", func() {
+		res := get("/billing/prod/feature(_)x")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(ContainSubstring(`"label":"feature/x"`))
+	})
+
+	It("serves the merged document as YAML", func() {
+		res := get("/billing-prod.yml")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(MatchYAML("db: {url: postgres://prod}\nhosts: [a, b]\nserver: {port: 8080}\n"))
+	})
+
+	It("serves the merged document as properties", func() {
+		res := get("/master/billing-prod.properties")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(Equal("db.url: postgres://prod\nhosts[0]: a\nhosts[1]: b\nserver.port: 8080\n"))
+	})
+
+	It("serves the merged document as JSON", func() {
+		res := get("/billing-default.json")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(MatchJSON(`{"db":{"url":"postgres://localhost"},"hosts":["a","b"],"server":{"port":8080}}`))
+	})
+
+	It("serves pinned documents", func() {
+		backend := &flakyBackend{sources: []*configserver.Source{
+			{Name: "billing", Origin: "flaky:billing", Data: map[string]interface{}{"db": map[string]interface{}{"pool": 5}}},
+		}}
+		server = configserver.NewServer([]configserver.Backend{backend}, log.New(GinkgoWriter, "", 0))
+		Expect(server.Load()).To(Succeed())
+		res := httptest.NewRecorder()
+		server.Handler().ServeHTTP(res, httptest.NewRequest("POST", "/config/billing/pin", nil))
+		Expect(res.Code).To(Equal(http.StatusOK))
+
+		backend.sources = []*configserver.Source{
+			{Name: "billing", Origin: "flaky:billing", Data: map[string]interface{}{"db": map[string]interface{}{"pool": 50}}},
+		}
+		Expect(server.Load()).To(Succeed())
+
+		pinned, err := configserver.DocumentVersion(map[string]interface{}{"db": map[string]interface{}{"pool": 5}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get("/billing/default").Body.String()).To(MatchJSON(`{
+			"name": "billing",
+			"profiles": ["default"],
+			"label": null,
+			"version": null,
+			"state": null,
+			"propertySources": [{"name": "pinned:` + pinned + `", "source": {"db.pool": 5}}]
+		}`))
+		Expect(get("/billing-default.yml").Body.String()).To(Equal("db:\n  pool: 5\n"))
+		Expect(get("/billing-prod.yml").Body.String()).To(Equal("db:\n  pool: 50\n"))
+	})
+
+	It("returns 404 for paths that are not part of the API", func() {
+		Expect(get("/").Code).To(Equal(http.StatusNotFound))
+		Expect(get("/billing").Code).To(Equal(http.StatusNotFound))
+		Expect(get("/billing.yml").Code).To(Equal(http.StatusNotFound))
+		Expect(get("/a/b/c/d").Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,228 @@
+package configserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/cloudfoundry/bosh-cli/director/template"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// VariableWriter is implemented by variable stores that can persist
+// generated values. SetVariable never replaces a value already stored, so
+// that instances generating the same variable at once agree on the first
+// value stored.
+type VariableWriter interface {
+	VariableStore
+	SetVariable(name string, value interface{}) error
+}
+
+// ReadOnlyVarsStore returns store as a VariableWriter that stores nothing,
+// for commands that must not change the store: variables it does not hold
+// yet fail instead of being generated.
+func ReadOnlyVarsStore(store VariableStore) VariableWriter {
+	return readOnlyVarsStore{store}
+}
+
+type readOnlyVarsStore struct {
+	VariableStore
+}
+
+func (s readOnlyVarsStore) SetVariable(name string, value interface{}) error {
+	return fmt.Errorf("variable %q is read only", name)
+}
+
+// NewVarsStore returns the store generated variables are persisted to, as
+// selected by $CONFIG_SERVER_VARS_STORE: "vault", "credhub" or the path of
+// a YAML file. It returns nil when no store is configured. The vault and
+// credhub stores reuse the enabled backend of the same name.
+func NewVarsStore(settings Settings, backends []Backend) (VariableWriter, error) {
+	switch settings.VarsStore {
+	case "":
+		return nil, nil
+	case "vault":
+		for _, backend := range backends {
+			if vault, ok := backend.(*VaultBackend); ok {
+				return vault, nil
+			}
+		}
+		return newVaultBackend(settings.Vault)
+	case "credhub":
+		for _, backend := range backends {
+			if credhub, ok := backend.(*CredHubBackend); ok {
+				return credhub, nil
+			}
+		}
+		return newCredHubBackend(settings.CredHub)
+	default:
+		return &FileVarsStore{Path: settings.VarsStore}, nil
+	}
+}
+
+// FileVarsStore persists variables to a YAML file, like the BOSH CLI's
+// --vars-store. The file is only shared by instances that share a disk, so
+// it suits local development rather than scaled apps.
+type FileVarsStore struct {
+	Path string
+}
+
+func (s *FileVarsStore) Variable(name string) (interface{}, bool, error) {
+	vars, err := s.load()
+	if err != nil {
+		return nil, false, err
+	}
+	value, found := vars[name]
+	return value, found, nil
+}
+
+// SetVariable rewrites the whole file atomically, unless it already holds
+// the variable.
+func (s *FileVarsStore) SetVariable(name string, value interface{}) error {
+	vars, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, found := vars[name]; found {
+		return nil
+	}
+	vars[name] = value
+
+	out, err := yaml.Marshal(vars)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.Path, out)
+}
+
+func (s *FileVarsStore) load() (map[string]interface{}, error) {
+	vars := map[string]interface{}{}
+	contents, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return vars, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(contents, &vars); err != nil {
+		return nil, fmt.Errorf("unable to parse vars store %s: %s", s.Path, err)
+	}
+	return vars, nil
+}
+
+// extractDefinitions removes the variables sections from sources,
+// returning the definitions they declare by name. A variables section is a
+// list of mappings with a name, as in a BOSH manifest:
+//
+//	variables:
+//	- name: session_key
+//	  type: password
+func extractDefinitions(sources []*Source) ([]*Source, map[string]VariableDefinition, error) {
+	definitions := map[string]VariableDefinition{}
+	extracted := make([]*Source, len(sources))
+	for n, source := range sources {
+		extracted[n] = source
+		list, ok := source.Data["variables"].([]interface{})
+		if !ok {
+			continue
+		}
+
+		var defs []VariableDefinition
+		for _, item := range list {
+			entry, _ := item.(map[string]interface{})
+			name, _ := entry["name"].(string)
+			if name == "" {
+				defs = nil
+				break
+			}
+			def := VariableDefinition{Name: name}
+			def.Type, _ = entry["type"].(string)
+			def.Options, _ = entry["options"].(map[string]interface{})
+			defs = append(defs, def)
+		}
+		if defs == nil {
+			continue
+		}
+
+		for _, def := range defs {
+			if existing, ok := definitions[def.Name]; ok && !reflect.DeepEqual(existing, def) {
+				return nil, nil, fmt.Errorf("%s: variable %q is declared differently in another document", source.Origin, def.Name)
+			}
+			definitions[def.Name] = def
+		}
+		data := make(map[string]interface{}, len(source.Data)-1)
+		for key, value := range source.Data {
+			if key != "variables" {
+				data[key] = value
+			}
+		}
+		extracted[n] = &Source{Name: source.Name, Origin: source.Origin, Data: data, Removed: source.Removed}
+	}
+	return extracted, definitions, nil
+}
+
+// generatedVariables generates declared variables that no other source
+// provides, persisting them to a store. Values are remembered for the
+// duration of one Interpolate call.
+type generatedVariables struct {
+	store       VariableWriter
+	definitions map[string]VariableDefinition
+	// lookup resolves CA variables through every source of variables.
+	lookup     template.Variables
+	values     map[string]interface{}
+	generating map[string]bool
+}
+
+func (g *generatedVariables) Get(def template.VariableDefinition) (interface{}, bool, error) {
+	definition, declared := g.definitions[def.Name]
+	if !declared || definition.Type == "" {
+		return nil, false, nil
+	}
+	if value, ok := g.values[def.Name]; ok {
+		return value, true, nil
+	}
+	if g.store == nil {
+		return nil, false, fmt.Errorf("variable %q of type %s needs $CONFIG_SERVER_VARS_STORE to be generated", def.Name, definition.Type)
+	}
+
+	value, found, err := g.store.Variable(def.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found {
+		if _, readOnly := g.store.(readOnlyVarsStore); readOnly {
+			return nil, false, fmt.Errorf("variable %q of type %s has not been generated yet, which only the sidecar does", def.Name, definition.Type)
+		}
+		if g.generating[def.Name] {
+			return nil, false, fmt.Errorf("variable %q is its own CA", def.Name)
+		}
+		g.generating[def.Name] = true
+		generated, err := GenerateVariable(definition, g.ca)
+		delete(g.generating, def.Name)
+		if err != nil {
+			return nil, false, err
+		}
+		// Another instance may have stored a value first. Stores never
+		// replace a stored value, so the value read back is that one and
+		// ours is discarded.
+		setErr := g.store.SetVariable(def.Name, generated)
+		if value, found, err = g.store.Variable(def.Name); err != nil {
+			return nil, false, err
+		}
+		if !found {
+			return nil, false, fmt.Errorf("unable to store generated variable %q: %v", def.Name, setErr)
+		}
+	}
+	value = yamlValue(normalize(value))
+	g.values[def.Name] = value
+	return value, true, nil
+}
+
+func (g *generatedVariables) ca(name string) (interface{}, bool, error) {
+	return g.lookup.Get(template.VariableDefinition{Name: name})
+}
+
+func (g *generatedVariables) List() ([]template.VariableDefinition, error) {
+	return nil, nil
+}
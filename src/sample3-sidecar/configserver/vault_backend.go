@@ -0,0 +1,136 @@
+package configserver
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// VaultBackend loads documents from a Vault KV version 2 secrets engine.
+// Every secret directly under Prefix is a document; following the Spring
+// Cloud Vault convention a secret named "billing,prod" holds the
+// billing-prod document.
+type VaultBackend struct {
+	Address string
+	Token   string
+	Mount   string
+	Prefix  string
+	Client  *http.Client
+}
+
+func (b *VaultBackend) Name() string {
+	return "vault"
+}
+
+func (b *VaultBackend) Remote() bool {
+	return true
+}
+
+func (b *VaultBackend) Load() ([]*Source, error) {
+	var list struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	found, err := b.get(b.path("metadata", "")+"?list=true", &list)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	keys := list.Data.Keys
+	sort.Strings(keys)
+
+	var sources []*Source
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		var secret struct {
+			Data struct {
+				Data map[string]interface{} `json:"data"`
+			} `json:"data"`
+		}
+		found, err := b.get(b.path("data", key), &secret)
+		if err != nil {
+			return nil, err
+		}
+		if !found || secret.Data.Data == nil {
+			continue
+		}
+
+		sources = append(sources, &Source{
+			Name:   strings.Replace(key, ",", "-", -1),
+			Origin: "vault:" + b.path("data", key),
+			Data:   secret.Data.Data,
+		})
+	}
+	return sources, nil
+}
+
+// Variable resolves ((name)) from the secret named name under Prefix. A
+// secret holding a single "value" key resolves to that value; otherwise it
+// resolves to the whole secret, so ((name.key)) selects a key.
+func (b *VaultBackend) Variable(name string) (interface{}, bool, error) {
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	found, err := b.get(b.path("data", name), &secret)
+	if err != nil || !found || secret.Data.Data == nil {
+		return nil, false, err
+	}
+	if value, ok := secret.Data.Data["value"]; ok && len(secret.Data.Data) == 1 {
+		return value, true, nil
+	}
+	return secret.Data.Data, true, nil
+}
+
+// SetVariable stores value as the secret named name under Prefix, wrapped
+// in a "value" key unless it is a mapping. The write only succeeds if the
+// secret does not exist yet, so concurrent instances cannot replace each
+// other's generated values.
+func (b *VaultBackend) SetVariable(name string, value interface{}) error {
+	data, ok := normalize(value).(map[string]interface{})
+	if !ok {
+		data = map[string]interface{}{"value": value}
+	}
+	body := map[string]interface{}{
+		"options": map[string]interface{}{"cas": 0},
+		"data":    data,
+	}
+	header := http.Header{"X-Vault-Token": {b.Token}}
+	return sendJSON(b.Client, "POST", strings.TrimSuffix(b.Address, "/")+"/v1/"+b.path("data", name), header, body)
+}
+
+// Check reports whether Vault is reachable and unsealed.
+func (b *VaultBackend) Check() error {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(b.Address, "/")+"/v1/sys/health?standbyok=true", nil)
+	if err != nil {
+		return err
+	}
+	return checkHealth(b.Client, req)
+}
+
+func (b *VaultBackend) path(kind, key string) string {
+	parts := []string{trimSlashes(b.Mount), kind}
+	if prefix := trimSlashes(b.Prefix); prefix != "" {
+		parts = append(parts, prefix)
+	}
+	if key != "" {
+		parts = append(parts, key)
+	}
+	return strings.Join(parts, "/")
+}
+
+// get fetches a Vault API path, returning false if it does not exist.
+func (b *VaultBackend) get(path string, out interface{}) (bool, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(b.Address, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Vault-Token", b.Token)
+
+	return getJSON(b.Client, req, out)
+}
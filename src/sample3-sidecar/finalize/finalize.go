@@ -12,6 +12,7 @@ type Stager interface {
 	DepDir() string
 	DepsIdx() string
 	DepsDir() string
+	WriteProfileD(string, string) error
 }
 
 type Manifest interface {
@@ -42,7 +43,21 @@ type Finalizer struct {
 func (f *Finalizer) Run() error {
 	f.Log.BeginStep("Configuring sample3-sidecar")
 
-	// TODO: Prepare app for launch here here...
-
+	if err := f.WriteSocketProfile(); err != nil {
+		return err
+	}
 	return nil
 }
+
+// socketProfile is sourced by the app and the sidecar at launch. When the app
+// sets CONFIG_SERVER_LISTEN=unix both agree on a socket path, which the
+// sidecar listens on instead of $CONFIG_SERVER_PORT.
+const socketProfile = `if [ "${CONFIG_SERVER_LISTEN:-tcp}" = "unix" ]; then
+  export CONFIG_SERVER_SOCKET="${CONFIG_SERVER_SOCKET:-${TMPDIR:-$HOME}/config-server.sock}"
+fi
+`
+
+// WriteSocketProfile exports the config-server socket path to the app.
+func (f *Finalizer) WriteSocketProfile() error {
+	return f.Stager.WriteProfileD("config-server-socket.sh", socketProfile)
+}
@@ -2,6 +2,14 @@ package finalize_test
 
 //go:generate mockgen -source=finalize.go --destination=mocks_test.go --package=finalize_test
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sample3-sidecar/finalize"
+
+	"github.com/cloudfoundry/libbuildpack"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -11,4 +19,50 @@ var _ = Describe("Finalize", func() {
 		Expect(false).To(Equal(false))
 	})
 	// TODO: Add tests here to check configure dependency functions work
+
+	Describe("WriteSocketProfile", func() {
+		var (
+			buildDir  string
+			depsDir   string
+			finalizer *finalize.Finalizer
+		)
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "sample3-sidecar.build.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "sample3-sidecar.deps.")
+			Expect(err).To(BeNil())
+
+			logger := libbuildpack.NewLogger(&bytes.Buffer{})
+			finalizer = &finalize.Finalizer{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		source := func(env ...string) string {
+			script := filepath.Join(depsDir, "0", "profile.d", "config-server-socket.sh")
+			cmd := exec.Command("bash", "-c", ". "+script+` && echo -n "$CONFIG_SERVER_SOCKET"`)
+			cmd.Env = append([]string{"HOME=/home/vcap/app"}, env...)
+			out, err := cmd.Output()
+			Expect(err).To(BeNil())
+			return string(out)
+		}
+
+		It("exports a socket path only when the app asks for a unix socket", func() {
+			Expect(finalizer.WriteSocketProfile()).To(Succeed())
+
+			Expect(source()).To(Equal(""))
+			Expect(source("CONFIG_SERVER_LISTEN=tcp")).To(Equal(""))
+			Expect(source("CONFIG_SERVER_LISTEN=unix")).To(Equal("/home/vcap/app/config-server.sock"))
+			Expect(source("CONFIG_SERVER_LISTEN=unix", "TMPDIR=/tmp/app")).To(Equal("/tmp/app/config-server.sock"))
+			Expect(source("CONFIG_SERVER_LISTEN=unix", "CONFIG_SERVER_SOCKET=/tmp/custom.sock")).To(Equal("/tmp/custom.sock"))
+		})
+	})
 })
@@ -24,6 +24,9 @@ var _ = Describe("Simple Integration Test", func() {
 		app.Manifest = filepath.Join(bpDir, "fixtures", "rubyapp", "manifest.cfdev.yml")
 		V3PushAppAndConfirm(app)
 		Expect(app.GetBody("/")).To(ContainSubstring("Hi, I'm an app with a sidecar!"))
-		Expect(app.GetBody("/config")).To(ContainSubstring(`{"Scope":"some-service.admin","Password":"not-a-real-p4$$w0rd"}`))
+		body, err := app.GetBody("/config")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(body).To(ContainSubstring(`"Scope":"some-service.admin"`))
+		Expect(body).To(ContainSubstring(`"Password":"not-a-real-p4$$w0rd"`))
 	})
 })
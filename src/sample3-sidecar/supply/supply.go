@@ -1,7 +1,14 @@
 package supply
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sample3-sidecar/configserver"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
@@ -12,6 +19,8 @@ type Stager interface {
 	DepDir() string
 	DepsIdx() string
 	DepsDir() string
+	WriteEnvFile(string, string) error
+	WriteProfileD(string, string) error
 }
 
 type Manifest interface {
@@ -52,5 +61,102 @@ func (s *Supplier) Run() error {
 	if err := s.Installer.InstallDependency(configServer, s.Stager.DepDir()); err != nil {
 		return err
 	}
+
+	if err := s.WriteToken(); err != nil {
+		return err
+	}
+	if err := s.BundleGitRepository(os.Getenv("CONFIG_SERVER_GIT_URI")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteToken generates the shared secret the web process and config-server
+// authenticate with, and exposes it to later buildpacks and to every process
+// at launch. Tokens of the other identities in the app's access policy are
+// never written to the droplet, where every process could read them: the
+// sidecar only needs the digests in their configserver.TokenHashVariable,
+// and each token is handed to the process it belongs to alone.
+func (s *Supplier) WriteToken() error {
+	s.Log.Info("Generating config-server token")
+
+	if err := s.checkAccessPolicy(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+
+	variable := configserver.TokenVariable(configserver.WebIdentity)
+	if err := s.Stager.WriteEnvFile(variable, token); err != nil {
+		return err
+	}
+	return s.Stager.WriteProfileD("config-server.sh", fmt.Sprintf("export %s=%s\n", variable, token))
+}
+
+// checkAccessPolicy parses the access policy config-server reads at launch,
+// found as by configserver.PolicyPath with the app directory as $HOME, and
+// warns about the identities that cannot authenticate yet.
+func (s *Supplier) checkAccessPolicy() error {
+	path, required := configserver.PolicyPath(func(name string) string {
+		if name == "HOME" {
+			return s.Stager.BuildDir()
+		}
+		return os.Getenv(name)
+	})
+	name := path
+	if rel, err := filepath.Rel(s.Stager.BuildDir(), path); err == nil {
+		name = rel
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if required {
+			s.Log.Warning("The access policy %s named by $CONFIG_SERVER_POLICY does not exist", name)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	policy, err := configserver.ParsePolicy(contents)
+	if err != nil {
+		return fmt.Errorf("unable to parse %s: %s", name, err)
+	}
+	for _, identity := range policy.Identities {
+		if identity.Name == configserver.WebIdentity {
+			if identity.Admin {
+				s.Log.Warning("The %s identity is an admin, and every process can read its token", identity.Name)
+			}
+		} else if os.Getenv(configserver.TokenHashVariable(identity.Name)) == "" {
+			s.Log.Warning("Set $%s for identity %q to start config-server", configserver.TokenHashVariable(identity.Name), identity.Name)
+		}
+	}
+	return nil
+}
+
+// BundleGitRepository mirrors the git repository at uri into the droplet,
+// where the git backend reads it at launch without network access. Staging
+// is the only time the repository is fetched, so config changes need a
+// restage. Nothing is done when uri is empty.
+func (s *Supplier) BundleGitRepository(uri string) error {
+	if uri == "" {
+		return nil
+	}
+	s.Log.Info("Bundling the config git repository")
+
+	dest := filepath.Join(s.Stager.BuildDir(), configserver.DefaultGitRepository)
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := s.Command.Execute(s.Stager.BuildDir(), s.Log.Output(), s.Log.Output(), "git", "clone", "--mirror", "--quiet", uri, dest); err != nil {
+		return fmt.Errorf("unable to clone $CONFIG_SERVER_GIT_URI: %s", err)
+	}
 	return nil
 }
@@ -1,6 +1,15 @@
 package supply_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sample3-sidecar/configserver"
+	"sample3-sidecar/supply"
+
+	"github.com/cloudfoundry/libbuildpack"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -12,4 +21,139 @@ var _ = Describe("Supply", func() {
 		Expect(false).To(Equal(false))
 	})
 	// TODO: Add tests here to check install dependency functions work
+
+	Describe("WriteToken", func() {
+		var (
+			buildDir string
+			depsDir  string
+			supplier *supply.Supplier
+		)
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "sample3-sidecar.build.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "sample3-sidecar.deps.")
+			Expect(err).To(BeNil())
+
+			logger := libbuildpack.NewLogger(&bytes.Buffer{})
+			supplier = &supply.Supplier{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("exposes the same random token to staging and launch", func() {
+			Expect(supplier.WriteToken()).To(Succeed())
+
+			token, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "env", "CONFIG_SERVER_TOKEN"))
+			Expect(err).To(BeNil())
+			Expect(string(token)).To(MatchRegexp(`^[0-9a-f]{64}$`))
+
+			profile, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "profile.d", "config-server.sh"))
+			Expect(err).To(BeNil())
+			Expect(string(profile)).To(Equal("export CONFIG_SERVER_TOKEN=" + string(token) + "\n"))
+		})
+
+		It("does not expose the tokens of other identities to every process", func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "config"), 0755)).To(Succeed())
+			policy := "identities:\n  web:\n    allow: ['*']\n  ops:\n    allow: ['*']\n    admin: true\n"
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "config", "access-policy.yml"), []byte(policy), 0644)).To(Succeed())
+
+			Expect(supplier.WriteToken()).To(Succeed())
+
+			web, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "env", "CONFIG_SERVER_TOKEN"))
+			Expect(err).To(BeNil())
+			env, err := ioutil.ReadDir(filepath.Join(depsDir, "0", "env"))
+			Expect(err).To(BeNil())
+			Expect(env).To(HaveLen(1))
+
+			profiles, err := ioutil.ReadDir(filepath.Join(depsDir, "0", "profile.d"))
+			Expect(err).To(BeNil())
+			Expect(profiles).To(HaveLen(1))
+			profile, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "profile.d", "config-server.sh"))
+			Expect(err).To(BeNil())
+			Expect(string(profile)).To(Equal("export CONFIG_SERVER_TOKEN=" + string(web) + "\n"))
+			Expect(string(profile)).NotTo(ContainSubstring(configserver.TokenVariable("ops")))
+		})
+
+		It("fails on an invalid access policy", func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "config"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "config", "access-policy.yml"), []byte("identity: {}\n"), 0644)).To(Succeed())
+
+			Expect(supplier.WriteToken()).To(MatchError(ContainSubstring("unable to parse config/access-policy.yml")))
+		})
+
+		It("reads the access policy named by $CONFIG_SERVER_POLICY from the app directory", func() {
+			Expect(os.Setenv("CONFIG_SERVER_POLICY", "policies/config-server.yml")).To(Succeed())
+			defer os.Unsetenv("CONFIG_SERVER_POLICY")
+			Expect(os.MkdirAll(filepath.Join(buildDir, "policies"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "policies", "config-server.yml"), []byte("identity: {}\n"), 0644)).To(Succeed())
+
+			Expect(supplier.WriteToken()).To(MatchError(ContainSubstring("unable to parse policies/config-server.yml")))
+		})
+
+		It("generates a new token for every staging", func() {
+			Expect(supplier.WriteToken()).To(Succeed())
+			first, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "env", "CONFIG_SERVER_TOKEN"))
+			Expect(err).To(BeNil())
+
+			Expect(supplier.WriteToken()).To(Succeed())
+			second, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "env", "CONFIG_SERVER_TOKEN"))
+			Expect(err).To(BeNil())
+
+			Expect(second).NotTo(Equal(first))
+		})
+	})
+
+	Describe("BundleGitRepository", func() {
+		var (
+			buildDir string
+			repo     string
+			supplier *supply.Supplier
+		)
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "sample3-sidecar.build.")
+			Expect(err).To(BeNil())
+			repo, err = ioutil.TempDir("", "sample3-sidecar.repo.")
+			Expect(err).To(BeNil())
+			Expect(exec.Command("git", "init", "--quiet", "--bare", repo).Run()).To(Succeed())
+
+			logger := libbuildpack.NewLogger(&bytes.Buffer{})
+			supplier = &supply.Supplier{
+				Stager:  libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+				Command: &libbuildpack.Command{},
+				Log:     logger,
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(repo)).To(Succeed())
+		})
+
+		It("mirrors the repository into the droplet", func() {
+			Expect(supplier.BundleGitRepository(repo)).To(Succeed())
+			Expect(filepath.Join(buildDir, configserver.DefaultGitRepository, "HEAD")).To(BeAnExistingFile())
+
+			// Restaging replaces the previous clone.
+			Expect(supplier.BundleGitRepository(repo)).To(Succeed())
+		})
+
+		It("does nothing without a repository", func() {
+			Expect(supplier.BundleGitRepository("")).To(Succeed())
+			Expect(filepath.Join(buildDir, ".config-server")).NotTo(BeAnExistingFile())
+		})
+
+		It("fails when the repository cannot be cloned", func() {
+			Expect(supplier.BundleGitRepository(filepath.Join(repo, "missing"))).To(MatchError(ContainSubstring("unable to clone $CONFIG_SERVER_GIT_URI")))
+		})
+	})
 })